@@ -5,28 +5,36 @@ import (
 	"fmt"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
 	"go.uber.org/atomic"
 	ggrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/go-kratos/kratos/v2"
 	"github.com/go-kratos/kratos/v2/middleware/recovery"
+	kratostracing "github.com/go-kratos/kratos/v2/middleware/tracing"
 	"github.com/go-kratos/kratos/v2/transport"
 	"github.com/go-kratos/kratos/v2/transport/grpc"
 	"github.com/go-kratos/kratos/v2/transport/http"
 	redisv8 "github.com/go-redis/redis/v8"
 
+	"github.com/go-goim/core/pkg/certs"
 	"github.com/go-goim/core/pkg/cmd"
 	"github.com/go-goim/core/pkg/config"
 	"github.com/go-goim/core/pkg/db/hbase"
 	"github.com/go-goim/core/pkg/db/mysql"
 	"github.com/go-goim/core/pkg/db/redis"
 	"github.com/go-goim/core/pkg/errors"
+	"github.com/go-goim/core/pkg/graceful"
+	"github.com/go-goim/core/pkg/health"
 	"github.com/go-goim/core/pkg/initialize"
 	"github.com/go-goim/core/pkg/log"
+	"github.com/go-goim/core/pkg/metrics"
 	"github.com/go-goim/core/pkg/mq"
 	"github.com/go-goim/core/pkg/registry"
+	"github.com/go-goim/core/pkg/tracing"
 )
 
 // Application is a common app entry.
@@ -37,21 +45,39 @@ type Application struct {
 	Register registry.RegisterDiscover
 	HTTPSrv  *http.Server
 	GrpcSrv  *grpc.Server
+	// AdminSrv serves /healthz, /readyz and /metrics in plain http if WithAdminAddr is set, so probes
+	// and scrapers need no client certificate when servers require mutual tls.
+	AdminSrv *http.Server
 	Config   *config.Config
 	Producer mq.Producer
 	Redis    *redisv8.Client
 	Consumer []mq.Consumer
+	// TLS keeps certificates of servers when tls is configured, use TLS.ClientConfig() to dial other services.
+	TLS *certs.Reloader
+	// Health serves /healthz, /readyz and grpc health service, components may call
+	// Health.SetNotReady to leave load balancers before shutdown.
+	Health *health.Health
 
 	host    string
 	options *options
+
+	shutdown       *shutdown
+	beforeStopOnce sync.Once
+	shutdownOnce   sync.Once
+	shutdownErr    error
+	shutdownReport ShutdownReport
 }
 
 type options struct {
-	metadata map[string]string
+	metadata        map[string]string
+	shutdownTimeout time.Duration
+	adminAddr       string
 }
 
 func newOptions(opts ...Option) *options {
-	opt := &options{}
+	opt := &options{
+		shutdownTimeout: graceful.DefaultTimeout,
+	}
 	for _, o := range opts {
 		o(opt)
 	}
@@ -70,6 +96,23 @@ func WithMetadata(k, v string) Option {
 	}
 }
 
+// WithShutdownTimeout sets deadline of whole shutdown since stop signal received, graceful.DefaultTimeout by default.
+func WithShutdownTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.shutdownTimeout = d
+		}
+	}
+}
+
+// WithAdminAddr serves /healthz, /readyz and /metrics by a plain http server listening on addr, e.g. when
+// servers require mutual tls. They are served by http server if not set.
+func WithAdminAddr(addr string) Option {
+	return func(o *options) {
+		o.adminAddr = addr
+	}
+}
+
 var (
 	useHostIP bool
 )
@@ -104,18 +147,38 @@ func InitApplication(opts ...Option) (*Application, error) {
 		Config:  cfg,
 		options: newOptions(opts...),
 	}
+	a.shutdown = newShutdown(a.options.shutdownTimeout)
 
 	if err := a.initHost(); err != nil {
 		return nil, err
 	}
 
-	var servers = make([]transport.Server, 0)
+	if err := a.initTLS(); err != nil {
+		return nil, err
+	}
+
+	// init tracing before servers and clients so that they use its provider
+	if err := tracing.Init(
+		tracing.WithServiceName(cfg.SrvConfig.GetName()),
+		tracing.WithConfig(cfg.Tracing),
+	); err != nil {
+		return nil, err
+	}
+
+	a.Health = health.Init(health.WithServices(cfg.SrvConfig.GetName()))
+	var servers = []transport.Server{a.shutdown.server("health", a.Health)}
 	// init http server
 	if err := a.initHTTPServer(); err != nil {
 		return nil, err
 	}
 	if a.HTTPSrv != nil {
-		servers = append(servers, a.HTTPSrv)
+		servers = append(servers, a.shutdown.server("http", a.HTTPSrv))
+	}
+
+	a.initAdminServer()
+	if a.AdminSrv != nil {
+		// not an endpoint of service, so it is not registered
+		servers = append(servers, &server{Server: a.AdminSrv, name: "admin", s: a.shutdown})
 	}
 
 	// init grpc server
@@ -123,7 +186,7 @@ func InitApplication(opts ...Option) (*Application, error) {
 		return nil, err
 	}
 	if a.GrpcSrv != nil {
-		servers = append(servers, a.GrpcSrv)
+		servers = append(servers, a.shutdown.server("grpc", a.GrpcSrv))
 	}
 
 	// init mq
@@ -141,6 +204,8 @@ func InitApplication(opts ...Option) (*Application, error) {
 		return nil, err
 	}
 
+	a.initHealthCheckers()
+
 	log.Info("init application success")
 	return a, nil
 }
@@ -176,6 +241,20 @@ func (a *Application) initHost() error {
 	return fmt.Errorf("not found host ip")
 }
 
+func (a *Application) initTLS() error {
+	if a.Config.TLS == nil {
+		return nil
+	}
+
+	r, err := certs.NewReloader(a.Config.TLS)
+	if err != nil {
+		return err
+	}
+
+	a.TLS = r
+	return nil
+}
+
 func (a *Application) initHTTPServer() error {
 	if a.Config.SrvConfig.Http == nil {
 		return nil
@@ -188,18 +267,43 @@ func (a *Application) initHTTPServer() error {
 
 	portStr := strconv.Itoa(int(a.Config.SrvConfig.Http.GetPort()))
 	log.Debug("http server", "host", a.host, "port", portStr, "timeout", timeout)
-	httpSrv := http.NewServer(
+	opts := []http.ServerOption{
 		http.Address(net.JoinHostPort(a.host, portStr)),
 		http.Middleware(
 			recovery.Recovery(),
+			kratostracing.Server(),
+			metrics.Server(),
 		),
 		http.Timeout(timeout),
-	)
-	a.HTTPSrv = httpSrv
+	}
+	if a.TLS != nil {
+		opts = append(opts, http.TLSConfig(a.TLS.ServerConfig()))
+	}
+
+	a.HTTPSrv = http.NewServer(opts...)
+	if a.options.adminAddr == "" {
+		a.handleAdmin(a.HTTPSrv)
+	}
 
 	return nil
 }
 
+func (a *Application) initAdminServer() {
+	if a.options.adminAddr == "" {
+		return
+	}
+
+	log.Debug("admin server", "addr", a.options.adminAddr)
+	a.AdminSrv = http.NewServer(http.Address(a.options.adminAddr))
+	a.handleAdmin(a.AdminSrv)
+}
+
+func (a *Application) handleAdmin(srv *http.Server) {
+	srv.HandleFunc("/healthz", a.Health.ServeLiveness)
+	srv.HandleFunc("/readyz", a.Health.ServeReadiness)
+	srv.Handle("/metrics", metrics.Handler())
+}
+
 func (a *Application) initGrpcServer() error {
 	if a.Config.SrvConfig.Grpc == nil {
 		return nil
@@ -212,19 +316,27 @@ func (a *Application) initGrpcServer() error {
 
 	portStr := strconv.Itoa(int(a.Config.SrvConfig.Grpc.GetPort()))
 	log.Debug("grpc server", "host", a.host, "port", portStr, "timeout", timeout)
-	grpcSrv := grpc.NewServer(
+	opts := []grpc.ServerOption{
 		grpc.Address(net.JoinHostPort(a.host, portStr)),
 		grpc.Middleware(
 			recovery.Recovery(),
+			kratostracing.Server(),
+			metrics.Server(),
 		),
 		grpc.Timeout(timeout),
+		grpc.CustomHealth(),
 		grpc.Options(
 			ggrpc.InitialWindowSize(1024*1024*1024),     // 1GB
 			ggrpc.InitialConnWindowSize(1024*1024*1024), // 1GB
 			ggrpc.MaxConcurrentStreams(1024),
 		),
-	)
-	a.GrpcSrv = grpcSrv
+	}
+	if a.TLS != nil {
+		opts = append(opts, grpc.TLSConfig(a.TLS.ServerConfig()))
+	}
+
+	a.GrpcSrv = grpc.NewServer(opts...)
+	grpc_health_v1.RegisterHealthServer(a.GrpcSrv, a.Health.GrpcServer())
 
 	return nil
 }
@@ -319,6 +431,9 @@ func (a *Application) initKratos(servers []transport.Server) error {
 		kratos.Metadata(
 			a.options.metadata,
 		),
+		kratos.StopTimeout(a.options.shutdownTimeout),
+		// kratos deregisters and stops servers after this on SIGTERM, SIGQUIT and SIGINT
+		kratos.BeforeStop(a.beforeStop),
 	}
 
 	reg, err := registry.NewRegistry(a.Config.RegConfig.Registry)
@@ -328,7 +443,7 @@ func (a *Application) initKratos(servers []transport.Server) error {
 
 	if reg != nil {
 		a.Register = reg
-		options = append(options, kratos.Registrar(reg))
+		options = append(options, kratos.Registrar(&registrar{Registrar: reg, s: a.shutdown}))
 	}
 
 	a.Core = kratos.New(
@@ -338,6 +453,29 @@ func (a *Application) initKratos(servers []transport.Server) error {
 	return nil
 }
 
+// initHealthCheckers adds readiness checkers of initialized dependencies.
+func (a *Application) initHealthCheckers() {
+	if a.Redis != nil {
+		a.Health.AddChecker(health.Redis(a.Redis))
+	}
+
+	if db := mysql.GetDB(); db != nil {
+		a.Health.AddChecker(health.MySQL(db))
+	}
+
+	if a.Config.SrvConfig.GetHBase() != nil {
+		a.Health.AddChecker(health.HBase(hbase.GetClient(), "hbase:meta"))
+	}
+
+	if a.Producer != nil {
+		a.Health.AddChecker(health.NameServer(a.Config.SrvConfig.Mq.GetAddr()))
+	}
+
+	if a.Register != nil {
+		a.Health.AddChecker(health.Registry(a.Register, a.Config.SrvConfig.GetName()))
+	}
+}
+
 func (a *Application) initMetadata() {
 	// metadata
 	metadata := make(map[string]string)
@@ -354,6 +492,9 @@ func (a *Application) initMetadata() {
 	a.options.metadata = metadata
 }
 
+// Run starts mq clients and servers, and blocks until stop signal received. On stop signal the
+// application is marked not ready and connections are drained, then it is deregistered and servers
+// are stopped, and Run calls Shutdown for the rest of shutdown and returns its error if servers returned none.
 func (a *Application) Run() error {
 	if err := initialize.BeforeRun(context.Background()); err != nil {
 		return err
@@ -370,57 +511,98 @@ func (a *Application) Run() error {
 		}
 	}
 
-	return a.Core.Run()
+	err := a.Core.Run()
+	if err != nil {
+		log.Error("application stopped with error", "error", err)
+	}
+
+	if serr := a.Shutdown(context.Background()); err == nil {
+		err = serr
+	}
+
+	return err
 }
 
+// Shutdown drains consumers and runs graceful hooks of graceful.PhaseStop within shutdown timeout and ctx,
+// then closes stores, clients and hooks of graceful.PhaseClose, each with its own timeout even if shutdown
+// timed out. Each of them is a step of shutdown.
+// It only runs once, later calls return the same error which contains errors of all failed steps.
 func (a *Application) Shutdown(ctx context.Context) error {
-	var (
-		es                 = make(errors.ErrorSet, 0)
-		checkCtxAndExecute = func(f func() error) {
-			select {
-			case <-ctx.Done():
-				es = append(es, ctx.Err())
-				return
-			default:
-			}
+	a.shutdownOnce.Do(func() {
+		a.shutdownErr = a.doShutdown(ctx)
+	})
 
-			if err := f(); err != nil {
-				es = append(es, err)
-			}
-		}
-	)
+	return a.shutdownErr
+}
 
-	if a.Producer != nil {
-		checkCtxAndExecute(func() error {
-			if err := a.Producer.Shutdown(); err != nil {
-				return fmt.Errorf("shutdown producer error: %w", err)
-			}
+// ShutdownReport returns timing and errors of shutdown steps, it is valid after Shutdown returned.
+func (a *Application) ShutdownReport() ShutdownReport {
+	return a.shutdownReport
+}
 
+// beforeStop leaves load balancers and drains connections by hooks of graceful.PhaseDrain before
+// servers stop, so that handlers of long-lived connections return and do not block servers stopping.
+func (a *Application) beforeStop(ctx context.Context) error {
+	var err error
+	a.beforeStopOnce.Do(func() {
+		_ = a.shutdown.step(ctx, "mark not ready", func(context.Context) error {
+			a.Health.Drain()
 			return nil
 		})
-	}
 
-	for _, consumer := range a.Consumer {
-		consumer := consumer
-		checkCtxAndExecute(func() error {
-			if err := consumer.Shutdown(); err != nil {
-				return fmt.Errorf("shutdown consumer error: %w", err)
+		err = a.shutdown.step(ctx, "drain connections", func(ctx context.Context) error {
+			return graceful.ShutdownPhase(ctx, graceful.PhaseDrain)
+		})
+	})
+
+	return err
+}
+
+func (a *Application) doShutdown(ctx context.Context) error {
+	// in case servers are not stopped by signal
+	_ = a.beforeStop(ctx)
+
+	if len(a.Consumer) > 0 {
+		_ = a.shutdown.step(ctx, "drain consumers", func(context.Context) error {
+			es := make(errors.ErrorSet, 0)
+			for _, consumer := range a.Consumer {
+				if err := consumer.Shutdown(); err != nil {
+					es = append(es, fmt.Errorf("shutdown consumer error: %w", err))
+				}
 			}
 
-			return nil
+			return es.Err()
+		})
+	}
+
+	_ = a.shutdown.step(ctx, "graceful hooks", func(ctx context.Context) error {
+		return graceful.ShutdownPhase(ctx, graceful.PhaseStop)
+	})
+
+	if a.Producer != nil {
+		_ = a.shutdown.closeStep("close producer", func(context.Context) error {
+			return a.Producer.Shutdown()
 		})
 	}
 
 	if a.Redis != nil {
-		checkCtxAndExecute(func() error {
-			if err := a.Redis.Close(); err != nil {
-				return fmt.Errorf("close redis error: %w", err)
-			}
-			return nil
+		_ = a.shutdown.closeStep("close redis", func(context.Context) error {
+			return a.Redis.Close()
 		})
 	}
 
-	return es.Err()
+	if a.TLS != nil {
+		_ = a.shutdown.closeStep("close tls", func(context.Context) error {
+			return a.TLS.Close()
+		})
+	}
+
+	_ = a.shutdown.closeStep("close hooks", func(ctx context.Context) error {
+		return graceful.ShutdownPhase(ctx, graceful.PhaseClose)
+	})
+
+	a.shutdownReport = a.shutdown.finish()
+	return a.shutdownReport.Err()
 }
 
 func (a *Application) AddConsumer(c mq.Consumer) {
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	kratosregistry "github.com/go-kratos/kratos/v2/registry"
+	"github.com/go-kratos/kratos/v2/transport"
+
+	"github.com/go-goim/core/pkg/errors"
+	"github.com/go-goim/core/pkg/log"
+)
+
+// ShutdownStep is timing and error of a step of shutdown.
+type ShutdownStep struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// ShutdownReport contains steps of shutdown in order they finished.
+type ShutdownReport struct {
+	Steps    []ShutdownStep
+	Duration time.Duration
+}
+
+// Err returns errors of all failed steps.
+func (r *ShutdownReport) Err() error {
+	es := make(errors.ErrorSet, 0)
+	for _, s := range r.Steps {
+		if s.Err != nil {
+			es = append(es, fmt.Errorf("%s: %w", s.Name, s.Err))
+		}
+	}
+
+	return es.Err()
+}
+
+// closeStepTimeout is timeout of each step closing stores and clients.
+const closeStepTimeout = 5 * time.Second
+
+// shutdown runs steps of shutdown within timeout since first step started and records them.
+type shutdown struct {
+	timeout time.Duration
+
+	beginOnce sync.Once
+	start     time.Time
+	deadline  time.Time
+
+	lock   sync.Mutex
+	report ShutdownReport
+}
+
+func newShutdown(timeout time.Duration) *shutdown {
+	return &shutdown{timeout: timeout}
+}
+
+func (s *shutdown) begin() {
+	s.beginOnce.Do(func() {
+		s.start = time.Now()
+		s.deadline = s.start.Add(s.timeout)
+		log.Info("shutdown started", "timeout", s.timeout)
+	})
+}
+
+// context returns ctx limited by deadline of shutdown.
+func (s *shutdown) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	s.begin()
+	return context.WithDeadline(ctx, s.deadline)
+}
+
+// step runs f with ctx and records it, f is skipped if ctx is already done.
+func (s *shutdown) step(ctx context.Context, name string, f func(ctx context.Context) error) error {
+	ctx, cancel := s.context(ctx)
+	defer cancel()
+
+	start := time.Now()
+	err := ctx.Err()
+	if err == nil {
+		err = f(ctx)
+	}
+
+	return s.record(name, start, err)
+}
+
+// closeStep runs f with its own timeout and records it, it runs even if deadline of shutdown passed
+// so that stores and clients are always closed.
+func (s *shutdown) closeStep(name string, f func(ctx context.Context) error) error {
+	s.begin()
+	ctx, cancel := context.WithTimeout(context.Background(), closeStepTimeout)
+	defer cancel()
+
+	start := time.Now()
+	return s.record(name, start, f(ctx))
+}
+
+func (s *shutdown) record(name string, start time.Time, err error) error {
+	st := ShutdownStep{Name: name, Duration: time.Since(start), Err: err}
+	s.lock.Lock()
+	s.report.Steps = append(s.report.Steps, st)
+	s.lock.Unlock()
+
+	if err != nil {
+		log.Error("shutdown step failed", "step", name, "duration", st.Duration, "error", err)
+	} else {
+		log.Info("shutdown step done", "step", name, "duration", st.Duration)
+	}
+
+	return err
+}
+
+// finish returns copy of report.
+func (s *shutdown) finish() ShutdownReport {
+	s.begin()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r := ShutdownReport{
+		Steps:    append([]ShutdownStep(nil), s.report.Steps...),
+		Duration: time.Since(s.start),
+	}
+	log.Info("shutdown finished", "duration", r.Duration, "steps", len(r.Steps), "error", r.Err())
+	return r
+}
+
+// registrar records Deregister as a step of shutdown.
+type registrar struct {
+	kratosregistry.Registrar
+	s *shutdown
+}
+
+func (r *registrar) Deregister(ctx context.Context, ins *kratosregistry.ServiceInstance) error {
+	return r.s.step(ctx, "deregister", func(ctx context.Context) error {
+		return r.Registrar.Deregister(ctx, ins)
+	})
+}
+
+// server records Stop as a step of shutdown.
+type server struct {
+	transport.Server
+	name string
+	s    *shutdown
+}
+
+func (srv *server) Stop(ctx context.Context) error {
+	return srv.s.step(ctx, "stop "+srv.name+" server", srv.Server.Stop)
+}
+
+// endpointServer keeps transport.Endpointer of server, kratos registers endpoints of servers implementing it.
+type endpointServer struct {
+	*server
+	transport.Endpointer
+}
+
+func (s *shutdown) server(name string, srv transport.Server) transport.Server {
+	ts := &server{Server: srv, name: name, s: s}
+	if e, ok := srv.(transport.Endpointer); ok {
+		return &endpointServer{server: ts, Endpointer: e}
+	}
+
+	return ts
+}
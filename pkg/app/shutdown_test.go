@@ -0,0 +1,116 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-kratos/kratos/v2"
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	redisv8 "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/go-goim/core/pkg/graceful"
+	"github.com/go-goim/core/pkg/health"
+)
+
+func closeOnce(ch chan struct{}) func(context.Context) error {
+	var once sync.Once
+	return func(context.Context) error {
+		once.Do(func() { close(ch) })
+		return nil
+	}
+}
+
+func TestApplication_Shutdown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	a := &Application{
+		Health:  health.New(),
+		Redis:   redisv8.NewClient(&redisv8.Options{Addr: mr.Addr()}),
+		options: newOptions(WithShutdownTimeout(300 * time.Millisecond)),
+	}
+	a.shutdown = newShutdown(a.options.shutdownTimeout)
+
+	// stream returns when connections are drained, block outlives shutdown timeout
+	drained, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	closed := make(chan struct{})
+	// hooks can not be unregistered, they are called again if test runs again
+	graceful.RegisterPhase(graceful.PhaseDrain, closeOnce(drained))
+	graceful.RegisterPhase(graceful.PhaseClose, closeOnce(closed))
+
+	started := make(chan struct{}, 2)
+	srv := khttp.NewServer(khttp.Address("127.0.0.1:0"))
+	srv.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-drained
+	})
+	srv.HandleFunc("/block", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+	endpoint, err := srv.Endpoint()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	a.Core = kratos.New(
+		kratos.Server(a.shutdown.server("http", srv)),
+		kratos.StopTimeout(a.options.shutdownTimeout),
+		kratos.BeforeStop(a.beforeStop),
+	)
+	errc := make(chan error, 1)
+	go func() { errc <- a.Run() }()
+
+	streamDone := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://" + endpoint.Host + "/stream")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		streamDone <- err
+	}()
+	go func() {
+		if resp, err := http.Get("http://" + endpoint.Host + "/block"); err == nil {
+			_ = resp.Body.Close()
+		}
+	}()
+	<-started
+	<-started
+
+	assert.NoError(t, a.Core.Stop())
+	select {
+	case err = <-errc:
+	case <-time.After(3 * time.Second):
+		t.Fatal("shutdown blocked by handler")
+	}
+	assert.Error(t, err)
+	assert.NoError(t, <-streamDone, "stream handler returned on drain")
+
+	r := a.ShutdownReport()
+	names := make([]string, 0, len(r.Steps))
+	errs := make(map[string]error, len(r.Steps))
+	for _, s := range r.Steps {
+		names = append(names, s.Name)
+		errs[s.Name] = s.Err
+	}
+	assert.Equal(t, []string{"mark not ready", "drain connections", "stop http server", "graceful hooks",
+		"close redis", "close hooks"}, names)
+	assert.NoError(t, errs["drain connections"])
+	assert.ErrorIs(t, errs["stop http server"], context.DeadlineExceeded)
+	assert.ErrorIs(t, errs["graceful hooks"], context.DeadlineExceeded)
+	assert.NoError(t, errs["close redis"])
+	assert.NoError(t, errs["close hooks"])
+
+	// stores are closed although shutdown timed out
+	assert.Equal(t, redisv8.ErrClosed, a.Redis.Ping(context.Background()).Err())
+	select {
+	case <-closed:
+	default:
+		t.Error("hooks of close phase not called")
+	}
+	assert.False(t, a.Health.Ready())
+}
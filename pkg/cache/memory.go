@@ -28,11 +28,11 @@ const (
 )
 
 func NewMemoryCache() Cache {
-	return &memoryCache{
+	return withMetrics("memory", &memoryCache{
 		size:  defaultSize,
 		items: make(map[string]*memoryCacheItem, defaultSize),
 		mu:    sync.RWMutex{},
-	}
+	})
 }
 
 func (m *memoryCache) Get(_ context.Context, key string) ([]byte, error) {
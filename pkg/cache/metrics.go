@@ -0,0 +1,51 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/go-goim/core/pkg/metrics"
+)
+
+var cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "cache",
+	Name:      "requests_total",
+	Help:      "Total number of cache reads by result of hit, miss or error.",
+}, []string{"cache", "op", "result"})
+
+// instrumented counts hit and miss of reads of Cache.
+type instrumented struct {
+	Cache
+	name string
+}
+
+func withMetrics(name string, c Cache) Cache {
+	metrics.Register(cacheRequests)
+	return &instrumented{Cache: c, name: name}
+}
+
+func (i *instrumented) observe(op string, err error) {
+	result := "hit"
+	switch {
+	case err == ErrCacheMiss:
+		result = "miss"
+	case err != nil:
+		result = "error"
+	}
+
+	cacheRequests.WithLabelValues(i.name, op, result).Inc()
+}
+
+func (i *instrumented) Get(ctx context.Context, key string) ([]byte, error) {
+	b, err := i.Cache.Get(ctx, key)
+	i.observe("get", err)
+	return b, err
+}
+
+func (i *instrumented) GetFromHash(ctx context.Context, key string, field string) ([]byte, error) {
+	b, err := i.Cache.GetFromHash(ctx, key, field)
+	i.observe("get_from_hash", err)
+	return b, err
+}
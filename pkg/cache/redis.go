@@ -17,9 +17,9 @@ var _ Cache = &redisCache{}
 
 // NewRedisCache creates a new redisCache instance.
 func NewRedisCache(cli *redisv8.Client) Cache { //nolint:deadcode,unused
-	return &redisCache{
+	return withMetrics("redis", &redisCache{
 		client: cli,
-	}
+	})
 }
 
 func (r *redisCache) Get(ctx context.Context, key string) ([]byte, error) {
@@ -0,0 +1,294 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-goim/core/pkg/log"
+)
+
+var (
+	ErrNoCertificate = errors.New("no certificate")
+	ErrSANNotAllowed = errors.New("peer certificate SAN not allowed")
+)
+
+const defaultReloadInterval = time.Minute
+
+// Config is tls config of servers and clients, read from "tls" of service config.
+type Config struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// CAFile is the bundle to verify peers, system roots are used by clients if empty.
+	CAFile string `json:"ca_file"`
+	// ClientAuth requires and verifies client certificates on servers, aka mutual tls.
+	ClientAuth bool `json:"client_auth"`
+	// AllowedSANs limits peer certificates to those have any of these DNS, IP, URI or email SANs,
+	// "*.example.com" matches any subdomain. Any peer verified by CA is allowed if empty.
+	AllowedSANs []string `json:"allowed_sans"`
+	// ServerName verified by clients, address of the call is used if empty.
+	ServerName string `json:"server_name"`
+	// ReloadIntervalSec is interval of checking files changed, 60 by default.
+	ReloadIntervalSec int64 `json:"reload_interval_sec"`
+}
+
+// Reloader keeps certificate and CA bundle of Config, and reloads them when files changed on disk,
+// so that rotated certificates are used by new connections without restarting.
+type Reloader struct {
+	cfg *Config
+
+	lock    sync.RWMutex
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+	modTime map[string]time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewReloader loads files of cfg and starts checking them in background.
+func NewReloader(cfg *Config) (*Reloader, error) {
+	r := &Reloader{
+		cfg:     cfg,
+		modTime: make(map[string]time.Time),
+		done:    make(chan struct{}),
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	interval := defaultReloadInterval
+	if cfg.ReloadIntervalSec > 0 {
+		interval = time.Duration(cfg.ReloadIntervalSec) * time.Second
+	}
+
+	var ctx context.Context
+	ctx, r.cancel = context.WithCancel(context.Background())
+	go r.watch(ctx, interval)
+
+	return r, nil
+}
+
+func (r *Reloader) watch(ctx context.Context, interval time.Duration) {
+	defer close(r.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+
+			// keep using the old ones if new files are broken, e.g. cert is written but key is not yet.
+			if err := r.reload(); err != nil {
+				log.Error("reload tls certificates failed", "cert", r.cfg.CertFile, "err", err)
+				continue
+			}
+			log.Info("tls certificates reloaded", "cert", r.cfg.CertFile, "ca", r.cfg.CAFile)
+		}
+	}
+}
+
+func (r *Reloader) files() []string {
+	var files []string
+	for _, f := range []string{r.cfg.CertFile, r.cfg.KeyFile, r.cfg.CAFile} {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+
+	return files
+}
+
+// changed reports whether any file is modified since last reload.
+func (r *Reloader) changed() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+
+		if !fi.ModTime().Equal(r.modTime[f]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Reloader) reload() error {
+	modTime := make(map[string]time.Time)
+	for _, f := range r.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return err
+		}
+		modTime[f] = fi.ModTime()
+	}
+
+	var cert *tls.Certificate
+	if r.cfg.CertFile != "" || r.cfg.KeyFile != "" {
+		c, err := tls.LoadX509KeyPair(r.cfg.CertFile, r.cfg.KeyFile)
+		if err != nil {
+			return err
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if r.cfg.CAFile != "" {
+		b, err := os.ReadFile(r.cfg.CAFile)
+		if err != nil {
+			return err
+		}
+
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificate found in %s", r.cfg.CAFile)
+		}
+	}
+
+	r.lock.Lock()
+	r.cert, r.pool, r.modTime = cert, pool, modTime
+	r.lock.Unlock()
+	return nil
+}
+
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, r.pool
+}
+
+// ServerConfig returns tls config of servers, which always uses the latest certificate and CA bundle.
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			if cert == nil {
+				return nil, ErrNoCertificate
+			}
+
+			c := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if r.cfg.ClientAuth {
+				c.ClientAuth = tls.RequireAndVerifyClientCert
+				c.ClientCAs = pool
+				c.VerifyConnection = func(cs tls.ConnectionState) error {
+					return r.verifySAN(cs.PeerCertificates)
+				}
+			}
+
+			return c, nil
+		},
+	}
+}
+
+// ClientConfig returns tls config of clients, which presents the latest certificate if any and
+// verifies servers with the latest CA bundle.
+func (r *Reloader) ClientConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: r.cfg.ServerName,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			if cert == nil {
+				// no certificate is sent
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+		// RootCAs can not be changed after created, so verification is done by VerifyConnection.
+		InsecureSkipVerify: true, // nolint: gosec
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return r.verifyServer(cs)
+		},
+	}
+}
+
+func (r *Reloader) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return ErrNoCertificate
+	}
+
+	_, pool := r.current()
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       cs.ServerName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+
+	if _, err := cs.PeerCertificates[0].Verify(opts); err != nil {
+		return err
+	}
+
+	return r.verifySAN(cs.PeerCertificates)
+}
+
+func (r *Reloader) verifySAN(certs []*x509.Certificate) error {
+	if len(r.cfg.AllowedSANs) == 0 {
+		return nil
+	}
+	if len(certs) == 0 {
+		return ErrNoCertificate
+	}
+
+	leaf := certs[0]
+	sans := make([]string, 0, len(leaf.DNSNames)+len(leaf.IPAddresses)+len(leaf.URIs)+len(leaf.EmailAddresses))
+	sans = append(sans, leaf.DNSNames...)
+	sans = append(sans, leaf.EmailAddresses...)
+	for _, ip := range leaf.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, u := range leaf.URIs {
+		sans = append(sans, u.String())
+	}
+
+	for _, allowed := range r.cfg.AllowedSANs {
+		for _, san := range sans {
+			if matchSAN(allowed, san) {
+				return nil
+			}
+		}
+	}
+
+	return ErrSANNotAllowed
+}
+
+// matchSAN reports whether san matches allowed, names are case-insensitive.
+func matchSAN(allowed, san string) bool {
+	allowed, san = strings.ToLower(allowed), strings.ToLower(san)
+	if strings.HasPrefix(allowed, "*.") {
+		return strings.HasSuffix(san, allowed[1:]) && len(san) > len(allowed)-1
+	}
+
+	return allowed == san
+}
+
+// Close stops reloading.
+func (r *Reloader) Close() error {
+	r.cancel()
+	<-r.done
+	return nil
+}
@@ -0,0 +1,213 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+// issue writes certificate of dnsName signed by ca and its key into dir, returns paths.
+func (ca *testCA) issue(t *testing.T, dir, name, dnsName string, serial int64) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	assert.NoError(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile, keyFile = filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, typ string, der []byte) {
+	assert.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600))
+}
+
+// handshake runs tls handshake between server and client, returns server cert serial seen by client.
+func handshake(server, client *tls.Config) (*big.Int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	defer ln.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		sc, err := ln.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer sc.Close()
+
+		srv := tls.Server(sc, server)
+		err = srv.Handshake()
+		if err == nil {
+			// client verifies server cert after server finished in tls1.3, wait for the result.
+			_, err = srv.Read(make([]byte, 1))
+		}
+		errc <- err
+	}()
+
+	cc, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		return nil, err
+	}
+	defer cc.Close()
+
+	cli := tls.Client(cc, client)
+	if err := cli.Handshake(); err != nil {
+		return nil, err
+	}
+	if _, err := cli.Write([]byte{1}); err != nil {
+		return nil, err
+	}
+	if err := <-errc; err != nil {
+		return nil, err
+	}
+
+	return cli.ConnectionState().PeerCertificates[0].SerialNumber, nil
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.crt")
+	writePEM(t, caFile, "CERTIFICATE", ca.cert.Raw)
+
+	srvCert, srvKey := ca.issue(t, dir, "server", "push.goim", 2)
+	cliCert, cliKey := ca.issue(t, dir, "client", "gateway.goim", 3)
+
+	server, err := NewReloader(&Config{
+		CertFile:          srvCert,
+		KeyFile:           srvKey,
+		CAFile:            caFile,
+		ClientAuth:        true,
+		AllowedSANs:       []string{"*.goim"},
+		ReloadIntervalSec: 1,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer server.Close()
+
+	client, err := NewReloader(&Config{CertFile: cliCert, KeyFile: cliKey, CAFile: caFile, ServerName: "push.goim"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+
+	serial, err := handshake(server.ServerConfig(), client.ClientConfig())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), serial.Int64())
+
+	// client without certificate is rejected by mutual tls
+	noCert, err := NewReloader(&Config{CAFile: caFile, ServerName: "push.goim"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer noCert.Close()
+	_, err = handshake(server.ServerConfig(), noCert.ClientConfig())
+	assert.Error(t, err)
+
+	// client certificate not in allowed SANs is rejected
+	otherCert, otherKey := ca.issue(t, dir, "other", "other.example.com", 4)
+	other, err := NewReloader(&Config{CertFile: otherCert, KeyFile: otherKey, CAFile: caFile, ServerName: "push.goim"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer other.Close()
+	_, err = handshake(server.ServerConfig(), other.ClientConfig())
+	assert.Error(t, err)
+
+	// SANs are case-insensitive
+	upperCert, upperKey := ca.issue(t, dir, "upper", "GATEWAY.GOIM", 6)
+	upper, err := NewReloader(&Config{CertFile: upperCert, KeyFile: upperKey, CAFile: caFile, ServerName: "push.goim"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer upper.Close()
+	_, err = handshake(server.ServerConfig(), upper.ClientConfig())
+	assert.NoError(t, err)
+
+	// rotated server certificate is used without restarting
+	time.Sleep(10 * time.Millisecond) // make sure mod time changed
+	ca.issue(t, dir, "server", "push.goim", 5)
+	assert.Eventually(t, func() bool {
+		serial, err = handshake(server.ServerConfig(), client.ClientConfig())
+		return err == nil && serial.Int64() == 5
+	}, 3*time.Second, 100*time.Millisecond)
+}
+
+func TestMatchSAN(t *testing.T) {
+	tests := []struct {
+		allowed string
+		san     string
+		want    bool
+	}{
+		{allowed: "push.goim", san: "push.goim", want: true},
+		{allowed: "push.goim", san: "PUSH.GOIM", want: true},
+		{allowed: "*.goim", san: "push.goim", want: true},
+		{allowed: "*.goim", san: "Push.GOIM", want: true},
+		{allowed: "*.GOIM", san: "push.goim", want: true},
+		{allowed: "*.goim", san: "goim", want: false},
+		{allowed: "*.goim", san: ".goim", want: false},
+		{allowed: "*.goim", san: "push.goim.example.com", want: false},
+		{allowed: "push.goim", san: "gateway.goim", want: false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchSAN(tt.allowed, tt.san), "%s %s", tt.allowed, tt.san)
+	}
+}
@@ -7,8 +7,10 @@ import (
 	registryv1 "github.com/go-goim/api/config/registry/v1"
 	configv1 "github.com/go-goim/api/config/v1"
 
+	"github.com/go-goim/core/pkg/certs"
 	"github.com/go-goim/core/pkg/cmd"
 	"github.com/go-goim/core/pkg/log"
+	"github.com/go-goim/core/pkg/tracing"
 )
 
 // Config contains service config.
@@ -18,6 +20,10 @@ type Config struct {
 	RegConfig          *RegistryConfig
 	ConfigSource       config.Source
 	EnableConfigCenter bool
+	// TLS is read from "tls" of service config, servers and clients use plaintext if nil.
+	TLS *certs.Config
+	// Tracing is read from "tracing" of service config, spans are not exported if nil.
+	Tracing *tracing.Config
 }
 
 // Debug returns true if service is running in debug mode.
@@ -119,6 +125,10 @@ func InitConfig() *Config {
 			panic(err)
 		}
 
+		if err := cfg.scanExtra(c); err != nil {
+			panic(err)
+		}
+
 		cfg.SrvConfig = sc
 		log.Debug("service content", "service", cfg)
 	}
@@ -143,9 +153,46 @@ func (c *Config) readFromConfigCenter() error {
 		return err
 	}
 
+	return c.scanExtra(cfg)
+}
+
+// scanExtra reads configs which are not in service proto, they are left nil if not set.
+func (c *Config) scanExtra(cfg config.Config) error {
+	tlsCfg := new(certs.Config)
+	ok, err := scanValue(cfg, "tls", tlsCfg)
+	if err != nil {
+		return err
+	}
+	if ok {
+		c.TLS = tlsCfg
+	}
+
+	tracingCfg := new(tracing.Config)
+	ok, err = scanValue(cfg, "tracing", tracingCfg)
+	if err != nil {
+		return err
+	}
+	if ok {
+		c.Tracing = tracingCfg
+	}
+
 	return nil
 }
 
+// scanValue scans value of key into v, returns false if key not set.
+func scanValue(c config.Config, key string, v interface{}) (bool, error) {
+	value := c.Value(key)
+	if value.Load() == nil {
+		return false, nil
+	}
+
+	if err := value.Scan(v); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 func setLogger(serviceName string, logConf *configv1.Log) {
 	var (
 		logPath = "./logs/" + serviceName
@@ -0,0 +1,292 @@
+package grpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/go-goim/core/pkg/log"
+)
+
+// ErrCircuitOpen is returned without calling when circuit breaker of the endpoint is open.
+var ErrCircuitOpen = status.Error(codes.Unavailable, "circuit breaker is open")
+
+// BreakerState is state of circuit breaker.
+type BreakerState int
+
+const (
+	// StateClosed lets all calls through and counts failures.
+	StateClosed BreakerState = iota
+	// StateOpen rejects all calls until open timeout elapsed.
+	StateOpen
+	// StateHalfOpen lets a few probe calls through, which close the breaker if all succeed.
+	StateHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateOpen:
+		return "open"
+	case StateHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+type breakerOptions struct {
+	window      time.Duration
+	minRequests int
+	errorRate   float64
+	openTimeout time.Duration
+	probes      int
+	isFailure   func(err error) bool
+	now         func() time.Time // clock, replaced in tests
+}
+
+const (
+	defaultBreakerWindow      = 10 * time.Second
+	defaultBreakerMinRequests = 20
+	defaultBreakerErrorRate   = 0.5
+	defaultBreakerOpenTimeout = 5 * time.Second
+	defaultBreakerProbes      = 3
+)
+
+func newBreakerOptions(opts ...BreakerOption) *breakerOptions {
+	o := &breakerOptions{
+		window:      defaultBreakerWindow,
+		minRequests: defaultBreakerMinRequests,
+		errorRate:   defaultBreakerErrorRate,
+		openTimeout: defaultBreakerOpenTimeout,
+		probes:      defaultBreakerProbes,
+		isFailure:   isServerFailure,
+		now:         time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+type BreakerOption func(o *breakerOptions)
+
+// WithBreakerThreshold opens breaker when error rate of calls in window reaches rate,
+// and there are at least minRequests calls in window.
+func WithBreakerThreshold(window time.Duration, minRequests int, rate float64) BreakerOption {
+	return func(o *breakerOptions) {
+		if window > 0 {
+			o.window = window
+		}
+		if minRequests > 0 {
+			o.minRequests = minRequests
+		}
+		if rate > 0 && rate <= 1 {
+			o.errorRate = rate
+		}
+	}
+}
+
+// WithBreakerOpenTimeout sets how long breaker stays open before letting probes calls through.
+func WithBreakerOpenTimeout(timeout time.Duration, probes int) BreakerOption {
+	return func(o *breakerOptions) {
+		if timeout > 0 {
+			o.openTimeout = timeout
+		}
+		if probes > 0 {
+			o.probes = probes
+		}
+	}
+}
+
+// WithBreakerFailure sets which errors are counted as failure, errors of unavailable, deadline exceeded,
+// resource exhausted, internal and unknown codes are failures by default.
+func WithBreakerFailure(f func(err error) bool) BreakerOption {
+	return func(o *breakerOptions) {
+		if f != nil {
+			o.isFailure = f
+		}
+	}
+}
+
+func isServerFailure(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return true
+	default:
+		return false
+	}
+}
+
+// Breakers keeps a circuit breaker per endpoint.
+type Breakers struct {
+	opts *breakerOptions
+
+	lock     sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewBreakers creates Breakers, plug it into pool with b.PoolOption().
+func NewBreakers(opts ...BreakerOption) *Breakers {
+	return &Breakers{
+		opts:     newBreakerOptions(opts...),
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// State returns state of breaker of endpoint.
+func (b *Breakers) State(endpoint string) BreakerState {
+	return b.get(endpoint).state()
+}
+
+func (b *Breakers) get(endpoint string) *breaker {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	br, ok := b.breakers[endpoint]
+	if !ok {
+		br = &breaker{opts: b.opts, endpoint: endpoint, windowStart: b.opts.now()}
+		b.breakers[endpoint] = br
+	}
+
+	return br
+}
+
+// PoolOption returns option which adds unary and stream interceptors to pool.
+func (b *Breakers) PoolOption() PoolOption {
+	return func(o *poolOptions) {
+		WithUnaryInterceptor(b.UnaryClientInterceptor())(o)
+		WithStreamInterceptor(b.StreamClientInterceptor())(o)
+	}
+}
+
+// UnaryClientInterceptor rejects calls with ErrCircuitOpen when breaker of the endpoint is open.
+func (b *Breakers) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		br := b.get(cc.Target())
+		if err := br.allow(); err != nil {
+			return err
+		}
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		br.done(err)
+		return err
+	}
+}
+
+// StreamClientInterceptor is the same as UnaryClientInterceptor but only counts result of creating stream.
+func (b *Breakers) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		br := b.get(cc.Target())
+		if err := br.allow(); err != nil {
+			return nil, err
+		}
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		br.done(err)
+		return cs, err
+	}
+}
+
+type breaker struct {
+	opts     *breakerOptions
+	endpoint string
+
+	lock        sync.Mutex
+	st          BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probing     int // probes in flight
+	probed      int // succeeded probes
+}
+
+func (br *breaker) state() BreakerState {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+	return br.st
+}
+
+func (br *breaker) allow() error {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+
+	now := br.opts.now()
+	switch br.st {
+	case StateOpen:
+		if now.Sub(br.openedAt) < br.opts.openTimeout {
+			return ErrCircuitOpen
+		}
+		br.setState(StateHalfOpen, now)
+		fallthrough
+	case StateHalfOpen:
+		if br.probing+br.probed >= br.opts.probes {
+			return ErrCircuitOpen
+		}
+		br.probing++
+	default:
+		if now.Sub(br.windowStart) >= br.opts.window {
+			br.windowStart, br.requests, br.failures = now, 0, 0
+		}
+	}
+
+	return nil
+}
+
+func (br *breaker) done(err error) {
+	br.lock.Lock()
+	defer br.lock.Unlock()
+
+	failed := err != nil && br.opts.isFailure(err)
+	now := br.opts.now()
+	switch br.st {
+	case StateHalfOpen:
+		if br.probing > 0 {
+			br.probing--
+		}
+		if failed {
+			br.setState(StateOpen, now)
+			return
+		}
+
+		br.probed++
+		if br.probed >= br.opts.probes {
+			br.setState(StateClosed, now)
+		}
+	case StateClosed:
+		br.requests++
+		if failed {
+			br.failures++
+		}
+
+		if br.requests >= br.opts.minRequests &&
+			float64(br.failures)/float64(br.requests) >= br.opts.errorRate {
+			br.setState(StateOpen, now)
+		}
+	default:
+		// result of call allowed before breaker opened
+	}
+}
+
+// setState must be called with lock held.
+func (br *breaker) setState(st BreakerState, now time.Time) {
+	log.Info("circuit breaker state changed", "endpoint", br.endpoint, "from", br.st.String(),
+		"to", st.String(), "requests", br.requests, "failures", br.failures)
+
+	br.st = st
+	br.probing, br.probed = 0, 0
+	br.windowStart, br.requests, br.failures = now, 0, 0
+	if st == StateOpen {
+		br.openedAt = now
+	}
+}
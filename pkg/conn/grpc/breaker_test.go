@@ -0,0 +1,104 @@
+package grpc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestBreaker_Transitions(t *testing.T) {
+	var (
+		ok          error
+		unavailable = status.Error(codes.Unavailable, "unavailable")
+		invalid     = status.Error(codes.InvalidArgument, "invalid")
+	)
+
+	type step struct {
+		advance time.Duration
+		err     error // result of call if allowed
+		allowed bool
+		want    BreakerState
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "open on error rate then close by probes",
+			steps: []step{
+				{err: ok, allowed: true, want: StateClosed},
+				{err: unavailable, allowed: true, want: StateClosed},
+				{err: invalid, allowed: true, want: StateClosed}, // not a failure
+				{err: unavailable, allowed: true, want: StateOpen},
+				{advance: 4 * time.Second, allowed: false, want: StateOpen},
+				{advance: time.Second, err: ok, allowed: true, want: StateHalfOpen},
+				{err: ok, allowed: true, want: StateClosed},
+				{err: unavailable, allowed: true, want: StateClosed}, // counts start over
+			},
+		},
+		{
+			name: "failed probe opens again",
+			steps: []step{
+				{err: unavailable, allowed: true, want: StateClosed},
+				{err: unavailable, allowed: true, want: StateClosed},
+				{err: unavailable, allowed: true, want: StateClosed},
+				{err: unavailable, allowed: true, want: StateOpen},
+				{advance: 5 * time.Second, err: unavailable, allowed: true, want: StateOpen},
+				{advance: time.Second, allowed: false, want: StateOpen},
+				{advance: 4 * time.Second, err: ok, allowed: true, want: StateHalfOpen},
+			},
+		},
+		{
+			name: "failures out of window are forgotten",
+			steps: []step{
+				{err: unavailable, allowed: true, want: StateClosed},
+				{err: unavailable, allowed: true, want: StateClosed},
+				{err: unavailable, allowed: true, want: StateClosed},
+				{advance: 10 * time.Second, err: unavailable, allowed: true, want: StateClosed},
+				{err: ok, allowed: true, want: StateClosed},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Unix(0, 0)
+			b := NewBreakers(WithBreakerThreshold(10*time.Second, 4, 0.5), WithBreakerOpenTimeout(5*time.Second, 2))
+			b.opts.now = func() time.Time { return now }
+
+			for i, s := range tt.steps {
+				now = now.Add(s.advance)
+				br := b.get("endpoint")
+				err := br.allow()
+				if assert.Equal(t, s.allowed, err == nil, "step %d", i) && err == nil {
+					br.done(s.err)
+				}
+				if !s.allowed {
+					assert.Equal(t, ErrCircuitOpen, err, "step %d", i)
+				}
+				assert.Equal(t, s.want, b.State("endpoint"), "step %d", i)
+			}
+		})
+	}
+}
+
+func TestBreaker_ProbesInFlight(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewBreakers(WithBreakerThreshold(time.Second, 1, 1), WithBreakerOpenTimeout(time.Second, 2))
+	b.opts.now = func() time.Time { return now }
+
+	br := b.get("endpoint")
+	assert.NoError(t, br.allow())
+	br.done(status.Error(codes.Internal, "internal"))
+	assert.Equal(t, StateOpen, br.state())
+
+	now = now.Add(time.Second)
+	assert.NoError(t, br.allow())
+	assert.NoError(t, br.allow())
+	assert.Equal(t, ErrCircuitOpen, br.allow(), "only probes are let through in half-open")
+	assert.Equal(t, StateHalfOpen, br.state())
+}
@@ -0,0 +1,290 @@
+package grpc
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"sync"
+
+	"github.com/go-kratos/kratos/v2/registry"
+	kratosGrpc "github.com/go-kratos/kratos/v2/transport/grpc"
+
+	"github.com/go-goim/core/pkg/errors"
+	"github.com/go-goim/core/pkg/log"
+	goimRegistry "github.com/go-goim/core/pkg/registry"
+)
+
+// WithDiscovery sets discovery used by ServicePool, registry.GetRegisterDiscover is used if not set.
+func WithDiscovery(d registry.Discovery) PoolOption {
+	return func(o *poolOptions) {
+		o.discovery = d
+	}
+}
+
+// ServicePool keeps a ConnPool for each instance of service, instances are added and removed
+// as registry watcher fires.
+type ServicePool struct {
+	name   string
+	opts   []PoolOption
+	picker Picker
+
+	lock      sync.RWMutex
+	instances map[string]*instancePool // instance id -> pool
+	ids       []string                 // sorted instance ids
+
+	watcher registry.Watcher
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+type instancePool struct {
+	*ConnPool
+	instance *registry.ServiceInstance
+	endpoint string
+}
+
+// Key implements Node.
+func (ip *instancePool) Key() string {
+	return ip.instance.ID
+}
+
+// InFlight implements Node.
+func (ip *instancePool) InFlight() int64 {
+	return ip.inFlight()
+}
+
+// NewServicePool creates ServicePool of service name and starts watching its instances.
+// Each instance gets a ConnPool created with opts, picker set by WithPicker picks instances
+// and connections of an instance are picked in round robin.
+func NewServicePool(name string, opts ...PoolOption) (*ServicePool, error) {
+	o := newPoolOptions(opts...)
+	d := o.discovery
+	if d == nil {
+		rd, err := goimRegistry.GetRegisterDiscover()
+		if err != nil {
+			return nil, err
+		}
+		d = rd
+	}
+
+	p := &ServicePool{
+		name:      name,
+		opts:      opts,
+		picker:    o.picker,
+		instances: make(map[string]*instancePool),
+		done:      make(chan struct{}),
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+
+	watcher, err := d.Watch(p.ctx, name)
+	if err != nil {
+		p.cancel()
+		return nil, err
+	}
+	p.watcher = watcher
+
+	// watcher may not fire until instances change, so load current instances first.
+	instances, err := d.GetService(p.ctx, name)
+	if err != nil {
+		log.Warn("get service instances failed", "service", name, "err", err)
+	} else {
+		p.update(instances)
+	}
+
+	go p.watch()
+	return p, nil
+}
+
+func (p *ServicePool) watch() {
+	defer close(p.done)
+	for {
+		instances, err := p.watcher.Next()
+		if err != nil {
+			if p.ctx.Err() == nil {
+				log.Error("watch service instances failed", "service", p.name, "err", err)
+			}
+			return
+		}
+
+		p.update(instances)
+	}
+}
+
+// update makes pools same as instances, pools of removed instances are released.
+// Pools of new instances are dialed without holding lock, so Get is not blocked meanwhile.
+func (p *ServicePool) update(instances []*registry.ServiceInstance) {
+	latest := make(map[string]*registry.ServiceInstance, len(instances))
+	for _, ins := range instances {
+		latest[ins.ID] = ins
+	}
+
+	// instances missing or changing endpoint
+	var changed []*registry.ServiceInstance
+	p.lock.RLock()
+	for id, ins := range latest {
+		if ip, ok := p.instances[id]; !ok || grpcEndpoint(ins) != ip.endpoint {
+			changed = append(changed, ins)
+		}
+	}
+	p.lock.RUnlock()
+
+	added := make(map[string]*instancePool, len(changed))
+	for _, ins := range changed {
+		endpoint := grpcEndpoint(ins)
+		if endpoint == "" {
+			log.Warn("instance has no grpc endpoint", "service", p.name, "id", ins.ID, "endpoints", ins.Endpoints)
+			continue
+		}
+
+		opts := make([]PoolOption, 0, len(p.opts)+2)
+		opts = append(opts, p.opts...)
+		opts = append(opts, WithClientOption(kratosGrpc.WithEndpoint(endpoint)), WithPicker(NewRoundRobinPicker()))
+		cp, err := NewConnPool(opts...)
+		if err != nil {
+			// retry at next update
+			log.Error("create instance conn pool failed", "service", p.name, "id", ins.ID, "err", err)
+			continue
+		}
+
+		added[ins.ID] = &instancePool{ConnPool: cp, instance: ins, endpoint: endpoint}
+	}
+
+	var removed, unused []*instancePool
+	p.lock.Lock()
+	for id, ip := range p.instances {
+		ins, ok := latest[id]
+		if ok && grpcEndpoint(ins) == ip.endpoint {
+			ip.instance = ins
+			continue
+		}
+
+		removed = append(removed, ip)
+		delete(p.instances, id)
+	}
+
+	for id, ip := range added {
+		if _, ok := p.instances[id]; ok {
+			// added by another update meanwhile
+			unused = append(unused, ip)
+			continue
+		}
+
+		p.instances[id] = ip
+		log.Info("instance added", "service", p.name, "id", id, "endpoint", ip.endpoint)
+	}
+
+	p.ids = p.ids[:0]
+	for id := range p.instances {
+		p.ids = append(p.ids, id)
+	}
+	sort.Strings(p.ids)
+	p.lock.Unlock()
+
+	for _, ip := range removed {
+		log.Info("instance removed", "service", p.name, "id", ip.instance.ID, "endpoint", ip.endpoint)
+		if err := ip.Release(); err != nil {
+			log.Error("release instance conn pool failed", "service", p.name, "id", ip.instance.ID, "err", err)
+		}
+	}
+	for _, ip := range unused {
+		_ = ip.Release()
+	}
+}
+
+// grpcEndpoint returns host of the first grpc endpoint of instance.
+func grpcEndpoint(ins *registry.ServiceInstance) string {
+	for _, e := range ins.Endpoints {
+		u, err := url.Parse(e)
+		if err != nil {
+			continue
+		}
+
+		if u.Scheme == "grpc" {
+			return u.Host
+		}
+	}
+
+	return ""
+}
+
+// Get is GetContext with background context.
+func (p *ServicePool) Get() (*ClientConn, error) {
+	return p.GetContext(context.Background())
+}
+
+// GetContext returns conn of the instance chosen by picker, instances without healthy conn are skipped.
+// ctx carries hash key of consistent hash picker.
+func (p *ServicePool) GetContext(ctx context.Context) (*ClientConn, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	nodes := make([]Node, 0, len(p.ids))
+	for _, id := range p.ids {
+		if ip := p.instances[id]; ip.healthy() {
+			nodes = append(nodes, ip)
+		}
+	}
+	if len(nodes) == 0 {
+		return nil, ErrNoAvailableInstance
+	}
+
+	return p.picker.Pick(ctx, nodes).(*instancePool).GetContext(ctx)
+}
+
+// GetByInstance returns conn of instance id, e.g. the push server a user is connected to.
+func (p *ServicePool) GetByInstance(id string) (*ClientConn, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	ip, ok := p.instances[id]
+	if !ok {
+		return nil, ErrInstanceNotFound
+	}
+
+	return ip.Get()
+}
+
+// Instances returns instances which have conn pool.
+func (p *ServicePool) Instances() []*registry.ServiceInstance {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	instances := make([]*registry.ServiceInstance, 0, len(p.ids))
+	for _, id := range p.ids {
+		instances = append(instances, p.instances[id].instance)
+	}
+
+	return instances
+}
+
+// Len returns count of instances.
+func (p *ServicePool) Len() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return len(p.ids)
+}
+
+// Release stops watching and releases conn pools of all instances.
+func (p *ServicePool) Release() error {
+	p.cancel()
+	stopErr := p.watcher.Stop()
+	<-p.done
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var es errors.ErrorSet
+	for _, ip := range p.instances {
+		if err := ip.Release(); err != nil {
+			es = append(es, err)
+		}
+	}
+	p.instances = make(map[string]*instancePool)
+	p.ids = nil
+
+	if stopErr != nil {
+		es = append(es, stopErr)
+	}
+	return es.Err()
+}
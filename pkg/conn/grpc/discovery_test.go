@@ -0,0 +1,133 @@
+package grpc
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/go-kratos/kratos/v2/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+// testDiscovery has no instance, and its watcher never fires until stopped.
+type testDiscovery struct{}
+
+func (testDiscovery) GetService(context.Context, string) ([]*registry.ServiceInstance, error) {
+	return nil, nil
+}
+
+func (testDiscovery) Watch(context.Context, string) (registry.Watcher, error) {
+	return &testWatcher{stop: make(chan struct{})}, nil
+}
+
+type testWatcher struct {
+	stop chan struct{}
+}
+
+func (w *testWatcher) Next() ([]*registry.ServiceInstance, error) {
+	<-w.stop
+	return nil, context.Canceled
+}
+
+func (w *testWatcher) Stop() error {
+	close(w.stop)
+	return nil
+}
+
+func testInstance(id, endpoint string) *registry.ServiceInstance {
+	return &registry.ServiceInstance{ID: id, Name: "test", Endpoints: []string{"http://" + endpoint, "grpc://" + endpoint}}
+}
+
+func countPools() int {
+	var n int
+	pools.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+
+	return n
+}
+
+func TestServicePool_Update(t *testing.T) {
+	basePools := countPools()
+	p, err := NewServicePool("test", WithDiscovery(testDiscovery{}), WithInsecure(), WithHealthInterval(0))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		instances []*registry.ServiceInstance
+		wantIDs   []string
+		keptIDs   []string // ids keeping pool of previous step
+	}{
+		{
+			name:      "add instances",
+			instances: []*registry.ServiceInstance{testInstance("a", "127.0.0.1:9001"), testInstance("b", "127.0.0.1:9002")},
+			wantIDs:   []string{"a", "b"},
+		},
+		{
+			name: "add one",
+			instances: []*registry.ServiceInstance{testInstance("a", "127.0.0.1:9001"),
+				testInstance("b", "127.0.0.1:9002"), testInstance("c", "127.0.0.1:9003")},
+			wantIDs: []string{"a", "b", "c"},
+			keptIDs: []string{"a", "b"},
+		},
+		{
+			name:      "remove one",
+			instances: []*registry.ServiceInstance{testInstance("a", "127.0.0.1:9001"), testInstance("c", "127.0.0.1:9003")},
+			wantIDs:   []string{"a", "c"},
+			keptIDs:   []string{"a", "c"},
+		},
+		{
+			name:      "endpoint changed",
+			instances: []*registry.ServiceInstance{testInstance("a", "127.0.0.1:9004"), testInstance("c", "127.0.0.1:9003")},
+			wantIDs:   []string{"a", "c"},
+			keptIDs:   []string{"c"},
+		},
+		{
+			name: "no grpc endpoint",
+			instances: []*registry.ServiceInstance{testInstance("a", "127.0.0.1:9004"),
+				{ID: "d", Name: "test", Endpoints: []string{"http://127.0.0.1:9005"}}},
+			wantIDs: []string{"a"},
+			keptIDs: []string{"a"},
+		},
+		{
+			name:    "remove all",
+			wantIDs: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		p.lock.RLock()
+		before := make(map[string]*instancePool, len(p.instances))
+		for id, ip := range p.instances {
+			before[id] = ip
+		}
+		p.lock.RUnlock()
+
+		p.update(tt.instances)
+
+		ids := make([]string, 0, p.Len())
+		for _, ins := range p.Instances() {
+			ids = append(ids, ins.ID)
+		}
+		assert.Equal(t, tt.wantIDs, ids, tt.name)
+		assert.True(t, sort.StringsAreSorted(p.ids), tt.name)
+
+		kept := make(map[string]bool, len(tt.keptIDs))
+		for _, id := range tt.keptIDs {
+			kept[id] = true
+			assert.Same(t, before[id], p.instances[id], "%s: pool of %s is kept", tt.name, id)
+		}
+		for id, ip := range before {
+			if !kept[id] {
+				assert.Equal(t, 0, ip.Len(), "%s: pool of %s is released", tt.name, id)
+			}
+		}
+		assert.Equal(t, basePools+len(tt.wantIDs), countPools(), "%s: no pool leaked", tt.name)
+	}
+
+	p.update([]*registry.ServiceInstance{testInstance("a", "127.0.0.1:9001")})
+	assert.NoError(t, p.Release())
+	assert.Equal(t, 0, p.Len())
+	assert.Equal(t, basePools, countPools())
+}
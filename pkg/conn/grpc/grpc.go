@@ -2,10 +2,13 @@ package grpc
 
 import (
 	"context"
+	"time"
 
 	kratosGrpc "github.com/go-kratos/kratos/v2/transport/grpc"
+	"go.uber.org/atomic"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health/grpc_health_v1"
 
 	"github.com/go-goim/api/errors"
 )
@@ -14,6 +17,13 @@ type ClientConn struct {
 	*grpc.ClientConn
 	kratosClientOpts []kratosGrpc.ClientOption
 	insecure         bool
+	serving          *atomic.Bool // set by health checking protocol
+	inFlight         *atomic.Int64
+	key              string    // set by pool
+	failedSince      time.Time // set by health check of pool, when it is seen in transient failure
+	unaryInts        []grpc.UnaryClientInterceptor
+	streamInts       []grpc.StreamClientInterceptor
+	grpcOpts         []grpc.DialOption
 
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -23,20 +33,35 @@ func NewClientConn(insecure bool, opts ...kratosGrpc.ClientOption) *ClientConn {
 	return &ClientConn{
 		insecure:         insecure,
 		kratosClientOpts: opts,
+		serving:          atomic.NewBool(true),
+		inFlight:         atomic.NewInt64(0),
 	}
 }
 
 func (c *ClientConn) Connect() error {
 	var dialFunc func(ctx context.Context, co ...kratosGrpc.ClientOption) (*grpc.ClientConn, error)
 	if c.insecure {
-		dialFunc = kratosGrpc.Dial
-	} else {
 		dialFunc = kratosGrpc.DialInsecure
+	} else {
+		dialFunc = kratosGrpc.Dial
 	}
 
 	c.ctx, c.cancel = context.WithCancel(context.Background())
 
-	cc, err := dialFunc(c.ctx, c.kratosClientOpts...)
+	// kratos WithOptions replaces previous one, so interceptors and dial options are merged into the last one.
+	// grpc chains of interceptors append to each other, interceptors of kratos WithUnaryInterceptor are kept.
+	grpcOpts := make([]grpc.DialOption, 0, len(c.grpcOpts)+2)
+	grpcOpts = append(grpcOpts,
+		grpc.WithChainUnaryInterceptor(append([]grpc.UnaryClientInterceptor{c.countInFlight}, c.unaryInts...)...),
+		grpc.WithChainStreamInterceptor(c.streamInts...),
+	)
+	grpcOpts = append(grpcOpts, c.grpcOpts...)
+
+	opts := make([]kratosGrpc.ClientOption, 0, len(c.kratosClientOpts)+1)
+	opts = append(opts, c.kratosClientOpts...)
+	opts = append(opts, kratosGrpc.WithOptions(grpcOpts...))
+
+	cc, err := dialFunc(c.ctx, opts...)
 	if err != nil {
 		return err
 	}
@@ -45,6 +70,37 @@ func (c *ClientConn) Connect() error {
 	return nil
 }
 
+func (c *ClientConn) countInFlight(ctx context.Context, method string, req, reply interface{},
+	cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	c.inFlight.Inc()
+	defer c.inFlight.Dec()
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// Key implements Node, it is index of the connection in pool or target if not in pool.
+func (c *ClientConn) Key() string {
+	if c.key != "" {
+		return c.key
+	}
+
+	return c.Target()
+}
+
+// InFlight implements Node.
+func (c *ClientConn) InFlight() int64 {
+	return c.inFlight.Load()
+}
+
+// healthy reports whether the connection is usable, idle one connects on use.
+func (c *ClientConn) healthy() bool {
+	switch c.GetState() {
+	case connectivity.TransientFailure, connectivity.Shutdown:
+		return false
+	default:
+		return c.serving.Load()
+	}
+}
+
 func (c *ClientConn) Close() error {
 	if c.cancel != nil {
 		c.cancel()
@@ -59,22 +115,35 @@ func (c *ClientConn) Close() error {
 // conn factory
 
 type clientConnFactory struct {
-	insecure bool
-	opts     []kratosGrpc.ClientOption
+	insecure      bool
+	opts          []kratosGrpc.ClientOption
+	unaryInts     []grpc.UnaryClientInterceptor
+	streamInts    []grpc.StreamClientInterceptor
+	grpcOpts      []grpc.DialOption
+	healthService *string // nil means not using health checking protocol
 }
 
+const (
+	healthCheckTimeout = time.Second
+)
+
 var (
-	ErrConnNotReady = errors.ErrorCode_InternalError.WithMessage("connection is not ready")
+	ErrConnNotReady        = errors.ErrorCode_InternalError.WithMessage("connection is not ready")
+	ErrNoAvailableInstance = errors.ErrorCode_InternalError.WithMessage("no available instance")
+	ErrInstanceNotFound    = errors.ErrorCode_InternalError.WithMessage("instance not found")
+	ErrConnNotServing      = errors.ErrorCode_InternalError.WithMessage("connection is not serving")
 )
 
 func newClientConnFactory(insecure bool, opts ...kratosGrpc.ClientOption) *clientConnFactory {
 	return &clientConnFactory{
-		opts: opts,
+		insecure: insecure,
+		opts:     opts,
 	}
 }
 
 func (c *clientConnFactory) Factory() (*ClientConn, error) {
 	cc := NewClientConn(c.insecure, c.opts...)
+	cc.unaryInts, cc.streamInts, cc.grpcOpts = c.unaryInts, c.streamInts, c.grpcOpts
 	if err := cc.Connect(); err != nil {
 		return nil, err
 	}
@@ -86,5 +155,20 @@ func (c *clientConnFactory) Ping(cc *ClientConn) error {
 	if cc.GetState() != connectivity.Ready {
 		return ErrConnNotReady
 	}
+
+	if c.healthService == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	resp, err := grpc_health_v1.NewHealthClient(cc).Check(ctx, &grpc_health_v1.HealthCheckRequest{Service: *c.healthService})
+	if err != nil {
+		return err
+	}
+	if resp.GetStatus() != grpc_health_v1.HealthCheckResponse_SERVING {
+		return ErrConnNotServing
+	}
 	return nil
 }
@@ -0,0 +1,61 @@
+package grpc
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/go-goim/core/pkg/metrics"
+)
+
+var (
+	// pools are pools not released yet, reported by metrics
+	pools sync.Map // *ConnPool -> struct{}
+
+	poolMetrics = &poolCollector{
+		conns: prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "grpc_pool", "connections"),
+			"Number of connections of grpc pools by target and state.", []string{"target", "state"}, nil),
+		inFlight: prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "grpc_pool", "in_flight"),
+			"Number of unary calls in flight of grpc pools by target.", []string{"target"}, nil),
+	}
+)
+
+// poolCollector reports state of all pools, pools of the same target are summed.
+type poolCollector struct {
+	conns    *prometheus.Desc
+	inFlight *prometheus.Desc
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.conns
+	ch <- c.inFlight
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	var (
+		stats    = make(map[string]PoolStats)
+		inFlight = make(map[string]int64)
+	)
+	pools.Range(func(key, _ interface{}) bool {
+		p := key.(*ConnPool)
+		target := p.target()
+		if target == "" {
+			return true
+		}
+
+		s, ps := stats[target], p.Stats()
+		s.Ready += ps.Ready
+		s.Connecting += ps.Connecting
+		s.Failed += ps.Failed
+		stats[target] = s
+		inFlight[target] += p.inFlight()
+		return true
+	})
+
+	for target, s := range stats {
+		ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(s.Ready), target, "ready")
+		ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(s.Connecting), target, "connecting")
+		ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(s.Failed), target, "failed")
+		ch <- prometheus.MustNewConstMetric(c.inFlight, prometheus.GaugeValue, float64(inFlight[target]), target)
+	}
+}
@@ -0,0 +1,206 @@
+package grpc
+
+import (
+	"context"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Node is a candidate of Picker, it is a connection in ConnPool or an instance in ServicePool.
+type Node interface {
+	// Key identifies node, it is stable while the node stays in pool.
+	Key() string
+	// InFlight returns count of unary calls in flight.
+	InFlight() int64
+}
+
+// Picker picks a node for a call from healthy nodes, nodes is never empty.
+type Picker interface {
+	Pick(ctx context.Context, nodes []Node) Node
+}
+
+// WithPicker sets strategy of picking connections and instances, round robin is used by default.
+func WithPicker(p Picker) PoolOption {
+	return func(o *poolOptions) {
+		if p != nil {
+			o.picker = p
+		}
+	}
+}
+
+type hashKeyCtxKey struct{}
+
+// WithHashKey returns ctx carrying key for consistent hash picker, e.g. uid or session id, so that
+// calls of the same key go to the same instance.
+func WithHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKeyCtxKey{}, key)
+}
+
+// HashKeyFromContext returns key set by WithHashKey.
+func HashKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(hashKeyCtxKey{}).(string)
+	return key, ok && key != ""
+}
+
+type roundRobinPicker struct {
+	next uint32 // atomic
+}
+
+// NewRoundRobinPicker returns Picker picking nodes in turn.
+func NewRoundRobinPicker() Picker {
+	return &roundRobinPicker{}
+}
+
+func (p *roundRobinPicker) Pick(_ context.Context, nodes []Node) Node {
+	return nodes[atomic.AddUint32(&p.next, 1)%uint32(len(nodes))]
+}
+
+type randomPicker struct{}
+
+// NewRandomPicker returns Picker picking nodes randomly.
+func NewRandomPicker() Picker {
+	return randomPicker{}
+}
+
+func (randomPicker) Pick(_ context.Context, nodes []Node) Node {
+	return nodes[rand.Intn(len(nodes))] // nolint: gosec
+}
+
+type leastInFlightPicker struct{}
+
+// NewLeastInFlightPicker returns Picker picking the node with least calls in flight,
+// ties are broken randomly.
+func NewLeastInFlightPicker() Picker {
+	return leastInFlightPicker{}
+}
+
+func (leastInFlightPicker) Pick(_ context.Context, nodes []Node) Node {
+	start := rand.Intn(len(nodes)) // nolint: gosec
+	picked := nodes[start]
+	for i := 1; i < len(nodes); i++ {
+		n := nodes[(start+i)%len(nodes)]
+		if n.InFlight() < picked.InFlight() {
+			picked = n
+		}
+	}
+
+	return picked
+}
+
+const defaultHashReplicas = 160
+
+type consistentHashPicker struct {
+	replicas int
+	fallback Picker
+
+	lock sync.RWMutex
+	ring *hashRing
+}
+
+// NewConsistentHashPicker returns Picker picking node by hash of key set by WithHashKey, each node has
+// replicas virtual nodes on the ring, so only keys on a node move when the node is added or removed.
+// Calls without key are picked in round robin.
+func NewConsistentHashPicker(replicas int) Picker {
+	if replicas <= 0 {
+		replicas = defaultHashReplicas
+	}
+
+	return &consistentHashPicker{
+		replicas: replicas,
+		fallback: NewRoundRobinPicker(),
+	}
+}
+
+func (p *consistentHashPicker) Pick(ctx context.Context, nodes []Node) Node {
+	key, ok := HashKeyFromContext(ctx)
+	if !ok {
+		return p.fallback.Pick(ctx, nodes)
+	}
+
+	keys := make([]string, len(nodes))
+	byKey := make(map[string]Node, len(nodes))
+	for i, n := range nodes {
+		keys[i] = n.Key()
+		byKey[keys[i]] = n
+	}
+	sort.Strings(keys)
+
+	return byKey[p.getRing(keys).get(key)]
+}
+
+// getRing returns ring of keys, it is rebuilt only when keys changed.
+func (p *consistentHashPicker) getRing(keys []string) *hashRing {
+	id := strings.Join(keys, "\x00")
+
+	p.lock.RLock()
+	ring := p.ring
+	p.lock.RUnlock()
+	if ring != nil && ring.id == id {
+		return ring
+	}
+
+	ring = newHashRing(id, keys, p.replicas)
+	p.lock.Lock()
+	p.ring = ring
+	p.lock.Unlock()
+	return ring
+}
+
+type hashRing struct {
+	id     string
+	hashes []uint64 // sorted
+	keys   map[uint64]string
+}
+
+func newHashRing(id string, keys []string, replicas int) *hashRing {
+	r := &hashRing{
+		id:     id,
+		hashes: make([]uint64, 0, len(keys)*replicas),
+		keys:   make(map[uint64]string, len(keys)*replicas),
+	}
+
+	for _, key := range keys {
+		for i := 0; i < replicas; i++ {
+			h := hashOf(strconv.Itoa(i) + "#" + key)
+			if _, ok := r.keys[h]; ok {
+				continue
+			}
+
+			r.keys[h] = key
+			r.hashes = append(r.hashes, h)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+
+	return r
+}
+
+// get returns node key of the first virtual node clockwise from hash of key.
+func (r *hashRing) get(key string) string {
+	h := hashOf(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0
+	}
+
+	return r.keys[r.hashes[i]]
+}
+
+// hashOf mixes fnv hash with splitmix64 finalizer, so that similar keys spread evenly on the ring.
+func hashOf(s string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(s))
+
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
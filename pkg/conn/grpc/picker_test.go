@@ -0,0 +1,103 @@
+package grpc
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testNode struct {
+	key      string
+	inFlight int64
+}
+
+func (n *testNode) Key() string     { return n.key }
+func (n *testNode) InFlight() int64 { return n.inFlight }
+
+func testNodes(keys ...string) []Node {
+	nodes := make([]Node, len(keys))
+	for i, k := range keys {
+		nodes[i] = &testNode{key: k}
+	}
+
+	return nodes
+}
+
+// pickAll returns node key picked for each hash key.
+func pickAll(p Picker, nodes []Node, keys []string) map[string]string {
+	m := make(map[string]string, len(keys))
+	for _, k := range keys {
+		m[k] = p.Pick(WithHashKey(context.Background(), k), nodes).Key()
+	}
+
+	return m
+}
+
+func TestConsistentHashPicker_Stability(t *testing.T) {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = "uid" + strconv.Itoa(i)
+	}
+
+	tests := []struct {
+		name   string
+		before []string
+		after  []string
+	}{
+		{name: "add node", before: []string{"a", "b", "c", "d"}, after: []string{"a", "b", "c", "d", "e"}},
+		{name: "remove node", before: []string{"a", "b", "c", "d", "e"}, after: []string{"a", "b", "d", "e"}},
+		{name: "reorder nodes", before: []string{"a", "b", "c"}, after: []string{"c", "a", "b"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewConsistentHashPicker(0)
+			before := pickAll(p, testNodes(tt.before...), keys)
+			assert.Equal(t, before, pickAll(p, testNodes(tt.before...), keys), "same nodes pick same node")
+
+			after := pickAll(p, testNodes(tt.after...), keys)
+			inBefore, inAfter := make(map[string]bool), make(map[string]bool)
+			for _, k := range tt.before {
+				inBefore[k] = true
+			}
+			for _, k := range tt.after {
+				inAfter[k] = true
+			}
+
+			var moved int
+			for _, k := range keys {
+				if before[k] == after[k] {
+					continue
+				}
+				moved++
+				// key moves only from a removed node or to an added node
+				assert.True(t, !inAfter[before[k]] || !inBefore[after[k]], "key %s moved from %s to %s",
+					k, before[k], after[k])
+			}
+
+			// about 1/n of keys move when a node is added or removed
+			changed := len(tt.before) - len(tt.after)
+			if changed < 0 {
+				changed = -changed
+			}
+			n := len(tt.before)
+			if len(tt.after) > n {
+				n = len(tt.after)
+			}
+			assert.LessOrEqual(t, float64(moved)/float64(len(keys)), 1.5*float64(changed)/float64(n))
+		})
+	}
+}
+
+func TestConsistentHashPicker_NoKey(t *testing.T) {
+	p := NewConsistentHashPicker(0)
+	nodes := testNodes("a", "b", "c")
+
+	picked := make(map[string]int)
+	for i := 0; i < 3; i++ {
+		picked[p.Pick(context.Background(), nodes).Key()]++
+	}
+	assert.Len(t, picked, 3, "calls without key are picked in round robin")
+}
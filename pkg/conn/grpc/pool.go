@@ -1,34 +1,94 @@
 package grpc
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
-	"sync/atomic"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/go-kratos/kratos/v2/registry"
 	kratosGrpc "github.com/go-kratos/kratos/v2/transport/grpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
 
 	"github.com/go-goim/core/pkg/errors"
+	"github.com/go-goim/core/pkg/log"
+	"github.com/go-goim/core/pkg/metrics"
 )
 
 type ConnPool struct {
 	factory *clientConnFactory
 	opts    *poolOptions
 
-	lastIndex int32 // atomic
-	conns     []*ClientConn
+	lock  sync.RWMutex
+	conns []*ClientConn
+
+	cancel context.CancelFunc
+	done   chan struct{}
 }
 
 type poolOptions struct {
-	dialInsecure bool
-	dialOpts     []kratosGrpc.ClientOption
-	size         int
+	dialInsecure   bool
+	dialOpts       []kratosGrpc.ClientOption
+	size           int
+	discovery      registry.Discovery
+	healthInterval time.Duration
+	replaceAfter   time.Duration
+	healthService  *string
+	picker         Picker
+	unaryInts      []grpc.UnaryClientInterceptor
+	streamInts     []grpc.StreamClientInterceptor
+	grpcOpts       []grpc.DialOption
+	tracing        bool
 }
 
+const (
+	defaultHealthInterval = 10 * time.Second
+	defaultReplaceAfter   = 30 * time.Second
+)
+
+// WithClientOption adds kratos client options.
+// Use WithDialOption instead of kratos WithOptions, which is replaced by interceptors of pool.
 func WithClientOption(opts ...kratosGrpc.ClientOption) PoolOption {
 	return func(o *poolOptions) {
 		o.dialOpts = append(o.dialOpts, opts...)
 	}
 }
 
+// WithUnaryInterceptor adds unary interceptors in order, the first one is the outermost.
+// They run inside interceptors of kratos WithUnaryInterceptor in WithClientOption.
+func WithUnaryInterceptor(in ...grpc.UnaryClientInterceptor) PoolOption {
+	return func(o *poolOptions) {
+		o.unaryInts = append(o.unaryInts, in...)
+	}
+}
+
+// WithStreamInterceptor adds stream interceptors in order, the first one is the outermost.
+func WithStreamInterceptor(in ...grpc.StreamClientInterceptor) PoolOption {
+	return func(o *poolOptions) {
+		o.streamInts = append(o.streamInts, in...)
+	}
+}
+
+// WithDialOption adds grpc dial options, they are merged with interceptors of pool.
+func WithDialOption(opts ...grpc.DialOption) PoolOption {
+	return func(o *poolOptions) {
+		o.grpcOpts = append(o.grpcOpts, opts...)
+	}
+}
+
+// WithTLS dials with tls config, e.g. certs.Reloader.ClientConfig() which presents client certificate
+// for mutual tls. Pools dial without tls unless WithTLS is set.
+func WithTLS(c *tls.Config) PoolOption {
+	return func(o *poolOptions) {
+		o.dialInsecure = false
+		o.dialOpts = append(o.dialOpts, kratosGrpc.WithTLSConfig(c))
+	}
+}
+
+// WithInsecure dials without tls, it is the default and undoes WithTLS.
 func WithInsecure() PoolOption {
 	return func(o *poolOptions) {
 		o.dialInsecure = true
@@ -41,52 +101,289 @@ func WithPoolSize(size int) PoolOption {
 	}
 }
 
+// WithHealthInterval sets interval of checking connections, broken ones are replaced.
+// Health check is disabled if interval <= 0.
+func WithHealthInterval(interval time.Duration) PoolOption {
+	return func(o *poolOptions) {
+		o.healthInterval = interval
+	}
+}
+
+// WithReplaceAfter sets how long a connection stays in transient failure before it is replaced,
+// grpc keeps reconnecting it with backoff until then.
+func WithReplaceAfter(d time.Duration) PoolOption {
+	return func(o *poolOptions) {
+		o.replaceAfter = d
+	}
+}
+
+// WithHealthService enables grpc health checking protocol of service on ready connections,
+// connections not serving are skipped by Get. Empty service checks the whole server.
+func WithHealthService(service string) PoolOption {
+	return func(o *poolOptions) {
+		o.healthService = &service
+	}
+}
+
 func newPoolOptions(opts ...PoolOption) *poolOptions {
 	o := &poolOptions{
-		size: 1,
+		dialInsecure:   true,
+		size:           1,
+		healthInterval: defaultHealthInterval,
+		replaceAfter:   defaultReplaceAfter,
+		tracing:        true,
 	}
 
 	for _, opt := range opts {
 		opt(o)
 	}
 
+	if o.picker == nil {
+		o.picker = NewRoundRobinPicker()
+	}
+
 	return o
 }
 
 type PoolOption func(opts *poolOptions)
 
-// TODO: conn pool should not be blocked and should try to reconnect
+// NewConnPool dials size connections and starts checking their health in background.
 func NewConnPool(opts ...PoolOption) (*ConnPool, error) {
 	p := &ConnPool{
 		opts: newPoolOptions(opts...),
+		done: make(chan struct{}),
 	}
 	p.conns = make([]*ClientConn, p.opts.size)
 
 	p.factory = newClientConnFactory(p.opts.dialInsecure, p.opts.dialOpts...)
+	p.factory.healthService = p.opts.healthService
+	p.factory.unaryInts, p.factory.streamInts = p.opts.unaryInts, p.opts.streamInts
+	if p.opts.tracing {
+		p.factory.unaryInts = append([]grpc.UnaryClientInterceptor{tracingUnaryInterceptor}, p.opts.unaryInts...)
+		p.factory.streamInts = append([]grpc.StreamClientInterceptor{tracingStreamInterceptor}, p.opts.streamInts...)
+	}
+	p.factory.grpcOpts = p.opts.grpcOpts
 	for i := 0; i < p.opts.size; i++ {
 		cc, err := p.factory.Factory()
 		if err != nil {
+			for _, c := range p.conns[:i] {
+				_ = c.Close()
+			}
 			return nil, err
 		}
 
+		cc.key = strconv.Itoa(i)
 		p.conns[i] = cc
 	}
 
+	var ctx context.Context
+	ctx, p.cancel = context.WithCancel(context.Background())
+	if p.opts.healthInterval > 0 {
+		go p.healthLoop(ctx)
+	} else {
+		close(p.done)
+	}
+
+	metrics.Register(poolMetrics)
+	pools.Store(p, struct{}{})
+
 	return p, nil
 }
 
+// Get is GetContext with background context.
 func (c *ConnPool) Get() (*ClientConn, error) {
+	return c.GetContext(context.Background())
+}
+
+// GetContext returns a healthy connection chosen by picker, ctx carries hash key of consistent hash picker.
+func (c *ConnPool) GetContext(ctx context.Context) (*ClientConn, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
 	if len(c.conns) == 0 {
 		return nil, fmt.Errorf("conn pool is empty")
 	}
 
-	cc := c.conns[atomic.AddInt32(&c.lastIndex, 1)%int32(c.opts.size)]
-	atomic.AddInt32(&c.lastIndex, 1)
+	nodes := c.healthyNodes()
+	if len(nodes) == 0 {
+		return nil, ErrConnNotReady
+	}
+
+	return c.opts.picker.Pick(ctx, nodes).(*ClientConn), nil
+}
+
+// healthyNodes must be called with lock held.
+func (c *ConnPool) healthyNodes() []Node {
+	nodes := make([]Node, 0, len(c.conns))
+	for _, cc := range c.conns {
+		if cc.healthy() {
+			nodes = append(nodes, cc)
+		}
+	}
 
-	return cc, nil
+	return nodes
+}
+
+// healthy reports whether any connection is healthy.
+func (c *ConnPool) healthy() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	for _, cc := range c.conns {
+		if cc.healthy() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// target returns target of connections, empty if released.
+func (c *ConnPool) target() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if len(c.conns) == 0 {
+		return ""
+	}
+
+	return c.conns[0].Target()
+}
+
+// inFlight returns count of unary calls in flight of all connections.
+func (c *ConnPool) inFlight() int64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	var n int64
+	for _, cc := range c.conns {
+		n += cc.InFlight()
+	}
+
+	return n
+}
+
+func (c *ConnPool) healthLoop(ctx context.Context) {
+	defer close(c.done)
+	ticker := time.NewTicker(c.opts.healthInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.checkHealth(ctx)
+		}
+	}
+}
+
+// checkHealth replaces closed connections and ones failing longer than replaceAfter,
+// wakes up idle ones and checks health of ready ones.
+func (c *ConnPool) checkHealth(ctx context.Context) {
+	c.lock.RLock()
+	conns := make([]*ClientConn, len(c.conns))
+	copy(conns, c.conns)
+	c.lock.RUnlock()
+
+	for i, cc := range conns {
+		switch cc.GetState() {
+		case connectivity.Idle:
+			cc.ClientConn.Connect()
+		case connectivity.Ready:
+			cc.failedSince = time.Time{}
+			if c.factory.healthService == nil {
+				continue
+			}
+
+			err := c.factory.Ping(cc)
+			if err != nil && cc.serving.Load() {
+				log.Warn("grpc conn not serving", "target", cc.Target(), "err", err)
+			}
+			cc.serving.Store(err == nil)
+		case connectivity.TransientFailure:
+			// grpc reconnects with backoff, replacing it at once redials every tick
+			if cc.failedSince.IsZero() {
+				cc.failedSince = time.Now()
+			}
+			if time.Since(cc.failedSince) < c.opts.replaceAfter {
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			c.replace(i, cc)
+		case connectivity.Shutdown:
+			if ctx.Err() != nil {
+				return
+			}
+			c.replace(i, cc)
+		}
+	}
+}
+
+func (c *ConnPool) replace(i int, old *ClientConn) {
+	cc, err := c.factory.Factory()
+	if err != nil {
+		log.Error("grpc conn reconnect failed", "target", old.Target(), "err", err)
+		return
+	}
+
+	c.lock.Lock()
+	if i >= len(c.conns) || c.conns[i] != old {
+		// released or replaced
+		c.lock.Unlock()
+		_ = cc.Close()
+		return
+	}
+	cc.key = old.key
+	c.conns[i] = cc
+	c.lock.Unlock()
+
+	log.Info("grpc conn replaced", "target", old.Target(), "state", old.GetState().String())
+	if err = old.Close(); err != nil {
+		log.Error("close broken grpc conn failed", "target", old.Target(), "err", err)
+	}
+}
+
+// PoolStats is count of connections in the pool by state.
+type PoolStats struct {
+	Ready      int
+	Connecting int // idle or connecting
+	Failed     int // transient failure, shutdown or not serving
+}
+
+func (c *ConnPool) Stats() PoolStats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	var s PoolStats
+	for _, cc := range c.conns {
+		switch cc.GetState() {
+		case connectivity.Ready:
+			if cc.serving.Load() {
+				s.Ready++
+			} else {
+				s.Failed++
+			}
+		case connectivity.Idle, connectivity.Connecting:
+			s.Connecting++
+		default:
+			s.Failed++
+		}
+	}
+
+	return s
 }
 
 func (c *ConnPool) Release() error {
+	pools.Delete(c)
+	c.cancel()
+	<-c.done
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
 	var es errors.ErrorSet
 	for _, conn := range c.conns {
 		if err := conn.Close(); err != nil {
@@ -100,5 +397,7 @@ func (c *ConnPool) Release() error {
 }
 
 func (c *ConnPool) Len() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	return len(c.conns)
 }
@@ -0,0 +1,160 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	kratosGrpc "github.com/go-kratos/kratos/v2/transport/grpc"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/go-goim/core/pkg/tracing"
+)
+
+// newTestServer serves grpc health service on a local port until test ends.
+func newTestServer(t *testing.T) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	srv := grpc.NewServer()
+	grpc_health_v1.RegisterHealthServer(srv, health.NewServer())
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	t.Cleanup(srv.Stop)
+
+	return lis.Addr().String()
+}
+
+// callRecorder records names of interceptors in order they are called.
+type callRecorder struct {
+	lock  sync.Mutex
+	calls []string
+}
+
+func (r *callRecorder) unary(name string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{},
+		cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		r.lock.Lock()
+		r.calls = append(r.calls, name)
+		r.lock.Unlock()
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func (r *callRecorder) get() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string(nil), r.calls...)
+}
+
+func TestConnPool_Interceptors(t *testing.T) {
+	addr := newTestServer(t)
+	r := &callRecorder{}
+
+	p, err := NewConnPool(
+		WithInsecure(),
+		WithHealthInterval(0),
+		WithClientOption(
+			kratosGrpc.WithEndpoint(addr),
+			kratosGrpc.WithUnaryInterceptor(r.unary("kratos")),
+		),
+		WithUnaryInterceptor(r.unary("pool")),
+		WithDialOption(grpc.WithChainUnaryInterceptor(r.unary("dial"))),
+	)
+	assert.NoError(t, err)
+	defer p.Release()
+
+	cc, err := p.Get()
+	assert.NoError(t, err)
+
+	resp, err := grpc_health_v1.NewHealthClient(cc).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, resp.GetStatus())
+	assert.Equal(t, []string{"kratos", "pool", "dial"}, r.get())
+	assert.Equal(t, int64(0), cc.InFlight())
+}
+
+func TestConnPool_InsecureByDefault(t *testing.T) {
+	addr := newTestServer(t)
+
+	// no WithTLS, pool dials plaintext server
+	p, err := NewConnPool(WithHealthInterval(0), WithClientOption(kratosGrpc.WithEndpoint(addr)))
+	assert.NoError(t, err)
+	defer p.Release()
+
+	cc, err := p.Get()
+	assert.NoError(t, err)
+	_, err = grpc_health_v1.NewHealthClient(cc).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+	assert.NoError(t, err)
+}
+
+func TestConnPool_Tracing(t *testing.T) {
+	exporter := tracing.NewMemoryExporter()
+	assert.Nil(t, tracing.Init(tracing.WithExporter(exporter), tracing.WithSyncExport()))
+	addr := newTestServer(t)
+
+	tests := []struct {
+		name      string
+		opts      []PoolOption
+		wantSpans int
+	}{
+		{name: "enabled by default", wantSpans: 1},
+		{name: "disabled", opts: []PoolOption{WithTracing(false)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			opts := append([]PoolOption{WithInsecure(), WithHealthInterval(0),
+				WithClientOption(kratosGrpc.WithEndpoint(addr))}, tt.opts...)
+			p, err := NewConnPool(opts...)
+			assert.NoError(t, err)
+			defer p.Release()
+
+			cc, err := p.Get()
+			assert.NoError(t, err)
+			_, err = grpc_health_v1.NewHealthClient(cc).Check(context.Background(), &grpc_health_v1.HealthCheckRequest{})
+			assert.NoError(t, err)
+
+			spans := exporter.GetSpans()
+			assert.Len(t, spans, tt.wantSpans)
+			if len(spans) > 0 {
+				assert.Equal(t, "/grpc.health.v1.Health/Check", spans[0].Name)
+			}
+		})
+	}
+}
+
+func TestConnPool_ReplaceAfter(t *testing.T) {
+	// nothing listens on the port, connection keeps failing
+	p, err := NewConnPool(
+		WithHealthInterval(0),
+		WithReplaceAfter(100*time.Millisecond),
+		WithClientOption(kratosGrpc.WithEndpoint("127.0.0.1:1")),
+	)
+	assert.NoError(t, err)
+	defer p.Release()
+
+	cc := p.conns[0]
+	cc.ClientConn.Connect()
+	assert.Eventually(t, func() bool {
+		return cc.GetState() == connectivity.TransientFailure
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// kept reconnecting with backoff of grpc
+	p.checkHealth(context.Background())
+	p.checkHealth(context.Background())
+	assert.Same(t, cc, p.conns[0])
+
+	time.Sleep(150 * time.Millisecond)
+	p.checkHealth(context.Background())
+	assert.NotSame(t, cc, p.conns[0])
+	assert.Equal(t, connectivity.Shutdown, cc.GetState())
+}
@@ -0,0 +1,236 @@
+package grpc
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/go-goim/core/pkg/log"
+)
+
+type retryOptions struct {
+	maxAttempts int
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	codes       map[codes.Code]bool
+	idempotent  func(method string) bool
+	// retry budget, see retryBudget
+	budgetTokens float64
+	budgetRatio  float64
+}
+
+const (
+	defaultRetryMaxAttempts  = 3
+	defaultRetryBaseBackoff  = 50 * time.Millisecond
+	defaultRetryMaxBackoff   = time.Second
+	defaultRetryBudgetTokens = 10
+	defaultRetryBudgetRatio  = 0.1
+)
+
+func newRetryOptions(opts ...RetryOption) *retryOptions {
+	o := &retryOptions{
+		maxAttempts:  defaultRetryMaxAttempts,
+		baseBackoff:  defaultRetryBaseBackoff,
+		maxBackoff:   defaultRetryMaxBackoff,
+		codes:        map[codes.Code]bool{codes.Unavailable: true},
+		idempotent:   func(string) bool { return false },
+		budgetTokens: defaultRetryBudgetTokens,
+		budgetRatio:  defaultRetryBudgetRatio,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+type RetryOption func(o *retryOptions)
+
+// WithRetryMaxAttempts sets max attempts of a call including the first one.
+func WithRetryMaxAttempts(n int) RetryOption {
+	return func(o *retryOptions) {
+		if n > 0 {
+			o.maxAttempts = n
+		}
+	}
+}
+
+// WithRetryBackoff sets exponential backoff between attempts, each wait is random in [0, min(max, base*2^n)).
+func WithRetryBackoff(base, max time.Duration) RetryOption {
+	return func(o *retryOptions) {
+		if base > 0 {
+			o.baseBackoff = base
+		}
+		if max >= o.baseBackoff {
+			o.maxBackoff = max
+		}
+	}
+}
+
+// WithRetryCodes sets codes to retry, only unavailable is retried by default.
+func WithRetryCodes(cs ...codes.Code) RetryOption {
+	return func(o *retryOptions) {
+		o.codes = make(map[codes.Code]bool, len(cs))
+		for _, c := range cs {
+			o.codes[c] = true
+		}
+	}
+}
+
+// WithIdempotentMethods sets full methods safe to retry, e.g. "/api.user.v1.UserService/GetUser".
+// No method is retried by default.
+func WithIdempotentMethods(methods ...string) RetryOption {
+	set := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		set[m] = true
+	}
+
+	return WithIdempotent(func(method string) bool {
+		return set[method]
+	})
+}
+
+// WithIdempotent sets func reports whether full method is safe to retry.
+func WithIdempotent(f func(method string) bool) RetryOption {
+	return func(o *retryOptions) {
+		if f != nil {
+			o.idempotent = f
+		}
+	}
+}
+
+// WithRetryBudget sets retry budget, each failed attempt takes a token and each succeeded call gives
+// back ratio token, retry is allowed only when more than half of tokens left.
+func WithRetryBudget(tokens, ratio float64) RetryOption {
+	return func(o *retryOptions) {
+		if tokens > 0 {
+			o.budgetTokens = tokens
+		}
+		if ratio > 0 {
+			o.budgetRatio = ratio
+		}
+	}
+}
+
+// Retrier retries idempotent calls with backoff, retries of all endpoints share the budget so
+// that a degraded service is not amplified by retries.
+type Retrier struct {
+	opts   *retryOptions
+	budget *retryBudget
+}
+
+// NewRetrier creates Retrier, plug it into pool with r.PoolOption().
+func NewRetrier(opts ...RetryOption) *Retrier {
+	o := newRetryOptions(opts...)
+	return &Retrier{
+		opts:   o,
+		budget: &retryBudget{max: o.budgetTokens, tokens: o.budgetTokens, ratio: o.budgetRatio},
+	}
+}
+
+// PoolOption returns option which adds unary and stream interceptors to pool, add it before
+// Breakers so that every attempt goes through circuit breaker.
+func (r *Retrier) PoolOption() PoolOption {
+	return func(o *poolOptions) {
+		WithUnaryInterceptor(r.UnaryClientInterceptor())(o)
+		WithStreamInterceptor(r.StreamClientInterceptor())(o)
+	}
+}
+
+// UnaryClientInterceptor retries idempotent calls failed with retryable codes.
+func (r *Retrier) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return r.do(ctx, method, func() error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})
+	}
+}
+
+// StreamClientInterceptor retries creating stream of idempotent methods, messages are never resent.
+func (r *Retrier) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+		streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		var cs grpc.ClientStream
+		err := r.do(ctx, method, func() (err error) {
+			cs, err = streamer(ctx, desc, cc, method, opts...)
+			return err
+		})
+		return cs, err
+	}
+}
+
+func (r *Retrier) do(ctx context.Context, method string, call func() error) error {
+	if !r.opts.idempotent(method) {
+		return call()
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil {
+			r.budget.success()
+			return nil
+		}
+
+		if err == ErrCircuitOpen || !r.opts.codes[status.Code(err)] {
+			return err
+		}
+
+		if !r.budget.failure() || attempt >= r.opts.maxAttempts {
+			return err
+		}
+
+		log.Debug("retry grpc call", "method", method, "attempt", attempt, "err", err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(r.backoff(attempt)):
+		}
+	}
+}
+
+func (r *Retrier) backoff(attempt int) time.Duration {
+	d := r.opts.baseBackoff << uint(attempt-1)
+	if d > r.opts.maxBackoff || d <= 0 {
+		d = r.opts.maxBackoff
+	}
+
+	return time.Duration(rand.Int63n(int64(d))) // nolint: gosec
+}
+
+// retryBudget is the retry throttling of grpc, see gRFC A6.
+type retryBudget struct {
+	lock   sync.Mutex
+	max    float64
+	tokens float64
+	ratio  float64
+}
+
+func (b *retryBudget) success() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tokens += b.ratio
+	if b.tokens > b.max {
+		b.tokens = b.max
+	}
+}
+
+// failure takes a token, returns whether retry is allowed.
+func (b *retryBudget) failure() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tokens--
+	if b.tokens < 0 {
+		b.tokens = 0
+	}
+
+	return b.tokens > b.max/2
+}
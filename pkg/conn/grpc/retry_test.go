@@ -0,0 +1,101 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const testMethod = "/test.Service/Get"
+
+// failing returns call failing with errs in order, then succeeding, and counter of attempts.
+func failing(errs ...error) (func() error, *int) {
+	var attempts int
+	return func() error {
+		attempts++
+		if attempts <= len(errs) {
+			return errs[attempts-1]
+		}
+		return nil
+	}, &attempts
+}
+
+func TestRetrier_Codes(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+
+	tests := []struct {
+		name         string
+		method       string
+		errs         []error
+		wantErr      error
+		wantAttempts int
+	}{
+		{name: "success", method: testMethod, wantAttempts: 1},
+		{name: "retry until success", method: testMethod, errs: []error{unavailable}, wantAttempts: 2},
+		{name: "max attempts", method: testMethod, errs: []error{unavailable, unavailable, unavailable, unavailable},
+			wantErr: unavailable, wantAttempts: 3},
+		{name: "not idempotent", method: "/test.Service/Create", errs: []error{unavailable},
+			wantErr: unavailable, wantAttempts: 1},
+		{name: "invalid argument", method: testMethod, errs: []error{status.Error(codes.InvalidArgument, "")},
+			wantErr: status.Error(codes.InvalidArgument, ""), wantAttempts: 1},
+		{name: "deadline exceeded", method: testMethod, errs: []error{status.Error(codes.DeadlineExceeded, "")},
+			wantErr: status.Error(codes.DeadlineExceeded, ""), wantAttempts: 1},
+		{name: "circuit open", method: testMethod, errs: []error{ErrCircuitOpen},
+			wantErr: ErrCircuitOpen, wantAttempts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRetrier(WithIdempotentMethods(testMethod), WithRetryBackoff(time.Microsecond, time.Microsecond))
+			call, attempts := failing(tt.errs...)
+
+			err := r.do(context.Background(), tt.method, call)
+			assert.Equal(t, tt.wantErr, err)
+			assert.Equal(t, tt.wantAttempts, *attempts)
+		})
+	}
+}
+
+func TestRetrier_Budget(t *testing.T) {
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+	r := NewRetrier(
+		WithIdempotentMethods(testMethod),
+		WithRetryMaxAttempts(10),
+		WithRetryBackoff(time.Microsecond, time.Microsecond),
+		WithRetryBudget(4, 0.5),
+	)
+
+	// calls run in order on the shared budget of 4 tokens, retry needs more than 2 tokens left.
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantTokens   float64
+	}{
+		{name: "retried once then exhausted", errs: []error{unavailable, unavailable, unavailable}, wantAttempts: 2,
+			wantTokens: 2},
+		{name: "no retry when exhausted", errs: []error{unavailable, unavailable}, wantAttempts: 1, wantTokens: 1},
+		{name: "success refills", wantAttempts: 1, wantTokens: 1.5},
+		{name: "success refills", wantAttempts: 1, wantTokens: 2},
+		{name: "not enough refilled", errs: []error{unavailable}, wantAttempts: 1, wantTokens: 1},
+		{name: "success refills", wantAttempts: 1, wantTokens: 1.5},
+		{name: "success refills", wantAttempts: 1, wantTokens: 2},
+		{name: "success refills", wantAttempts: 1, wantTokens: 2.5},
+		{name: "success refills", wantAttempts: 1, wantTokens: 3},
+		{name: "success refills", wantAttempts: 1, wantTokens: 3.5},
+		{name: "success refills", wantAttempts: 1, wantTokens: 4},
+		{name: "refilled up to max", wantAttempts: 1, wantTokens: 4},
+		{name: "retried after refill", errs: []error{unavailable}, wantAttempts: 2, wantTokens: 3.5},
+	}
+
+	for _, tt := range tests {
+		call, attempts := failing(tt.errs...)
+		_ = r.do(context.Background(), testMethod, call)
+		assert.Equal(t, tt.wantAttempts, *attempts, tt.name)
+		assert.Equal(t, tt.wantTokens, r.budget.tokens, tt.name)
+	}
+}
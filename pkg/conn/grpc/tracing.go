@@ -0,0 +1,144 @@
+package grpc
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/go-goim/core/pkg/tracing"
+)
+
+// WithTracing sets whether pool starts client span of each call and propagates trace context in metadata,
+// it is enabled by default and spans go to provider installed by tracing.Init. Tracing interceptors are
+// the outermost ones, so a call retried by Retrier has one span.
+func WithTracing(enabled bool) PoolOption {
+	return func(o *poolOptions) {
+		o.tracing = enabled
+	}
+}
+
+func tracingUnaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+	invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, span := startClientSpan(ctx, method, cc.Target())
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	endClientSpan(span, err)
+	return err
+}
+
+func tracingStreamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string,
+	streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, span := startClientSpan(ctx, method, cc.Target())
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		endClientSpan(span, err)
+		return nil, err
+	}
+
+	s := &tracedStream{ClientStream: cs, desc: desc, span: span, done: make(chan struct{})}
+	// stream abandoned by caller is finished when ctx is done
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.end(ctx.Err())
+		case <-s.done:
+		}
+	}()
+
+	return s, nil
+}
+
+func startClientSpan(ctx context.Context, method, target string) (context.Context, trace.Span) {
+	ctx, span := tracing.Tracer().Start(ctx, method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.RPCSystemKey.String("grpc"),
+			semconv.NetPeerNameKey.String(target),
+		))
+
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = md.Copy()
+	tracing.Propagator().Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md), span
+}
+
+func endClientSpan(span trace.Span, err error) {
+	span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(status.Code(err))))
+	tracing.End(span, err)
+}
+
+// tracedStream ends span when stream finished: on the only reply if server does not stream,
+// otherwise on io.EOF or error of RecvMsg, on error of CloseSend or Header, or when ctx is done.
+type tracedStream struct {
+	grpc.ClientStream
+	desc *grpc.StreamDesc
+	span trace.Span
+	once sync.Once
+	done chan struct{}
+}
+
+func (s *tracedStream) end(err error) {
+	s.once.Do(func() {
+		if err == io.EOF {
+			err = nil
+		}
+		endClientSpan(s.span, err)
+		close(s.done)
+	})
+}
+
+func (s *tracedStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil || !s.desc.ServerStreams {
+		s.end(err)
+	}
+
+	return err
+}
+
+func (s *tracedStream) CloseSend() error {
+	err := s.ClientStream.CloseSend()
+	if err != nil {
+		s.end(err)
+	}
+
+	return err
+}
+
+func (s *tracedStream) Header() (metadata.MD, error) {
+	md, err := s.ClientStream.Header()
+	if err != nil {
+		s.end(err)
+	}
+
+	return md, err
+}
+
+// metadataCarrier adapts metadata.MD to propagation.TextMapCarrier.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
@@ -0,0 +1,133 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/go-goim/core/pkg/tracing"
+)
+
+// testStream returns recvErrs in order on RecvMsg, and nil after that.
+type testStream struct {
+	grpc.ClientStream
+	recvErrs  []error
+	closeErr  error
+	headerErr error
+}
+
+func (s *testStream) RecvMsg(interface{}) error {
+	if len(s.recvErrs) == 0 {
+		return nil
+	}
+
+	err := s.recvErrs[0]
+	s.recvErrs = s.recvErrs[1:]
+	return err
+}
+
+func (s *testStream) CloseSend() error {
+	return s.closeErr
+}
+
+func (s *testStream) Header() (metadata.MD, error) {
+	return nil, s.headerErr
+}
+
+func TestTracedStream(t *testing.T) {
+	exporter := tracing.NewMemoryExporter()
+	assert.Nil(t, tracing.Init(tracing.WithExporter(exporter), tracing.WithSyncExport()))
+
+	// never connects, only target is used
+	cc, err := grpc.Dial("127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer cc.Close()
+
+	errBroken := errors.New("broken")
+	tests := []struct {
+		name          string
+		serverStreams bool
+		stream        *testStream
+		// calls made in order: "recv", "close" or "header"
+		calls      []string
+		wantEnded  bool
+		wantStatus codes.Code
+	}{
+		{name: "unary reply ends", calls: []string{"close", "recv"}, stream: &testStream{},
+			wantEnded: true, wantStatus: codes.Unset},
+		{name: "server stream open", serverStreams: true, calls: []string{"recv", "recv"}, stream: &testStream{}},
+		{name: "server stream eof", serverStreams: true, calls: []string{"recv", "recv"},
+			stream: &testStream{recvErrs: []error{nil, io.EOF}}, wantEnded: true, wantStatus: codes.Unset},
+		{name: "server stream error", serverStreams: true, calls: []string{"recv"},
+			stream: &testStream{recvErrs: []error{errBroken}}, wantEnded: true, wantStatus: codes.Error},
+		{name: "close send error", serverStreams: true, calls: []string{"close"},
+			stream: &testStream{closeErr: errBroken}, wantEnded: true, wantStatus: codes.Error},
+		{name: "header error", serverStreams: true, calls: []string{"header"},
+			stream: &testStream{headerErr: errBroken}, wantEnded: true, wantStatus: codes.Error},
+		{name: "ended once", serverStreams: true, calls: []string{"header", "recv"},
+			stream: &testStream{headerErr: errBroken, recvErrs: []error{io.EOF}}, wantEnded: true,
+			wantStatus: codes.Error},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter.Reset()
+			cs, err := tracingStreamInterceptor(context.Background(), &grpc.StreamDesc{ServerStreams: tt.serverStreams},
+				cc, "/test.Service/Watch", func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string,
+					...grpc.CallOption) (grpc.ClientStream, error) {
+					return tt.stream, nil
+				})
+			assert.NoError(t, err)
+
+			for _, c := range tt.calls {
+				switch c {
+				case "recv":
+					_ = cs.RecvMsg(nil)
+				case "close":
+					_ = cs.CloseSend()
+				case "header":
+					_, _ = cs.Header()
+				}
+			}
+
+			spans := exporter.GetSpans()
+			if !tt.wantEnded {
+				assert.Empty(t, spans)
+				return
+			}
+			if assert.Len(t, spans, 1) {
+				assert.Equal(t, tt.wantStatus, spans[0].Status.Code)
+			}
+		})
+	}
+}
+
+func TestTracedStream_ContextDone(t *testing.T) {
+	exporter := tracing.NewMemoryExporter()
+	assert.Nil(t, tracing.Init(tracing.WithExporter(exporter), tracing.WithSyncExport()))
+
+	cc, err := grpc.Dial("127.0.0.1:1", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	defer cc.Close()
+
+	// stream abandoned by caller
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err = tracingStreamInterceptor(ctx, &grpc.StreamDesc{ServerStreams: true}, cc, "/test.Service/Watch",
+		func(context.Context, *grpc.StreamDesc, *grpc.ClientConn, string, ...grpc.CallOption) (grpc.ClientStream, error) {
+			return &testStream{}, nil
+		})
+	assert.NoError(t, err)
+	assert.Empty(t, exporter.GetSpans())
+
+	cancel()
+	assert.Eventually(t, func() bool { return len(exporter.GetSpans()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, codes.Error, exporter.GetSpans()[0].Status.Code)
+}
@@ -0,0 +1,249 @@
+package ws
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-goim/core/pkg/goroutine"
+	"github.com/go-goim/core/pkg/log"
+	"github.com/go-goim/core/pkg/types"
+)
+
+// OfflineFallback receives payload of data frames which are neither acknowledged by client
+// nor able to be kept in unacked buffer, e.g. pushes them to user offline queue which
+// described by consts.UserOfflineQueueKeyPrefix.
+type OfflineFallback func(ctx context.Context, uid types.ID, payload []byte) error
+
+var (
+	offlineFallback OfflineFallback
+	sessions        = newAckSessions()
+)
+
+// SetOfflineFallback sets fallback for frames lost by ack sessions. Frames are dropped if not set.
+func SetOfflineFallback(f OfflineFallback) {
+	offlineFallback = f
+}
+
+// ackSession keeps sequence and unacked frames of a connection key, it outlives the connection
+// for resume ttl so that client can resume from its last seq after reconnect.
+type ackSession struct {
+	lock    sync.Mutex
+	uid     types.ID
+	key     string
+	size    int
+	ttl     time.Duration
+	seq     uint64 // last assigned seq
+	unacked []*unackedFrame
+	owner   *WebsocketConn
+	timer   *time.Timer
+}
+
+type unackedFrame struct {
+	seq     uint64
+	mt      int
+	payload []byte
+}
+
+// wrap assigns seq to payload and keeps it until acknowledged, returns encoded envelope.
+func (s *ackSession) wrap(mt int, payload []byte) ([]byte, error) {
+	s.lock.Lock()
+	env := &Envelope{Kind: KindData, Seq: s.seq + 1, Payload: payload}
+	data, err := EncodeEnvelope(mt, env)
+	if err != nil {
+		s.lock.Unlock()
+		return nil, err
+	}
+
+	s.seq++
+	s.unacked = append(s.unacked, &unackedFrame{seq: env.Seq, mt: mt, payload: payload})
+	var evicted []*unackedFrame
+	if n := len(s.unacked) - s.size; n > 0 {
+		evicted = append(evicted, s.unacked[:n]...)
+		s.unacked = append(s.unacked[:0:0], s.unacked[n:]...)
+	}
+	s.lock.Unlock()
+
+	s.fallback(evicted)
+	return data, nil
+}
+
+// ack removes frames with seq less or equal than given seq.
+func (s *ackSession) ack(seq uint64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.ackLocked(seq)
+}
+
+func (s *ackSession) ackLocked(seq uint64) {
+	i := 0
+	for i < len(s.unacked) && s.unacked[i].seq <= seq {
+		i++
+	}
+	s.unacked = s.unacked[i:]
+}
+
+func (s *ackSession) unackedCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.unacked)
+}
+
+// fallback passes frames to offline fallback asynchronously.
+func (s *ackSession) fallback(frames []*unackedFrame) {
+	if len(frames) == 0 {
+		return
+	}
+
+	f := offlineFallback
+	if f == nil {
+		log.Warn("websocket unacked frames dropped", "key", s.key, "count", len(frames))
+		return
+	}
+
+	task := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, frame := range frames {
+			if err := f(ctx, s.uid, frame.payload); err != nil {
+				log.Error("websocket offline fallback error", "key", s.key, "seq", frame.seq, "error", err)
+			}
+		}
+	}
+	if err := goroutine.Submit(task); err != nil {
+		task()
+	}
+}
+
+type ackSessions struct {
+	sync.Mutex
+	m map[string]*ackSession
+}
+
+func newAckSessions() *ackSessions {
+	return &ackSessions{
+		m: make(map[string]*ackSession),
+	}
+}
+
+// attach binds session of w's key to w. If resume is true and the session is still kept,
+// it returns frames after lastSeq to replay, and whether some frames after lastSeq are lost.
+func (ss *ackSessions) attach(w *WebsocketConn, o *options) (s *ackSession, replay []*unackedFrame, gap bool) {
+	ss.Lock()
+	defer ss.Unlock()
+
+	key := w.Key()
+	old, ok := ss.m[key]
+	if ok && o.resume {
+		old.lock.Lock()
+		defer old.lock.Unlock()
+		if old.timer != nil {
+			old.timer.Stop()
+			old.timer = nil
+		}
+		old.owner = w
+		old.size = o.ackBufferSize
+		old.ttl = o.resumeTTL
+		old.ackLocked(o.lastSeq)
+		replay = append(replay, old.unacked...)
+		gap = o.lastSeq < old.seq && (len(old.unacked) == 0 || old.unacked[0].seq > o.lastSeq+1)
+		return old, replay, gap
+	}
+
+	if ok {
+		old.lock.Lock()
+		if old.timer != nil {
+			old.timer.Stop()
+		}
+		lost := old.unacked
+		old.unacked = nil
+		old.lock.Unlock()
+		old.fallback(lost)
+	}
+
+	s = &ackSession{
+		uid:   w.uid,
+		key:   key,
+		size:  o.ackBufferSize,
+		ttl:   o.resumeTTL,
+		owner: w,
+	}
+	ss.m[key] = s
+	return s, nil, false
+}
+
+// detach unbinds w from its session, session expires after resume ttl if not attached again.
+func (ss *ackSessions) detach(w *WebsocketConn) {
+	s := w.ack
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.owner != w {
+		return
+	}
+
+	s.owner = nil
+	s.timer = time.AfterFunc(s.ttl, func() {
+		ss.expire(s)
+	})
+}
+
+func (ss *ackSessions) expire(s *ackSession) {
+	ss.Lock()
+	if ss.m[s.key] == s {
+		s.lock.Lock()
+		attached := s.owner != nil
+		s.lock.Unlock()
+		if attached {
+			ss.Unlock()
+			return
+		}
+		delete(ss.m, s.key)
+	}
+	ss.Unlock()
+
+	s.lock.Lock()
+	lost := s.unacked
+	s.unacked = nil
+	s.lock.Unlock()
+	s.fallback(lost)
+}
+
+// replay writes frames to client directly, it must be called before the write daemon starts.
+func (w *WebsocketConn) replay(frames []*unackedFrame, gap bool) error {
+	write := func(mt int, env *Envelope) error {
+		data, err := EncodeEnvelope(mt, env)
+		if err != nil {
+			return err
+		}
+
+		_ = w.conn.SetWriteDeadline(time.Now().Add(time.Second))
+		w.enableCompression(len(data))
+		if err := w.conn.WriteMessage(mt, data); err != nil {
+			return err
+		}
+
+		w.payloadBytes.Add(uint64(len(data)))
+		return nil
+	}
+
+	if gap {
+		w.ack.lock.Lock()
+		first := w.ack.seq + 1
+		if len(frames) > 0 {
+			first = frames[0].seq
+		}
+		w.ack.lock.Unlock()
+
+		if err := write(w.codec.FrameType(), &Envelope{Kind: KindResync, Seq: first}); err != nil {
+			return err
+		}
+	}
+
+	for _, f := range frames {
+		if err := write(f.mt, &Envelope{Kind: KindData, Seq: f.seq, Payload: f.payload}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
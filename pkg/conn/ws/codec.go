@@ -0,0 +1,111 @@
+package ws
+
+import (
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec encodes and decodes messages of a connection.
+// Codec is negotiated at upgrade time through Sec-WebSocket-Protocol header, and
+// Name is the subprotocol it answers to.
+type Codec interface {
+	Name() string
+	// FrameType returns websocket.TextMessage or websocket.BinaryMessage.
+	FrameType() int
+	Marshal(m proto.Message) ([]byte, error)
+	Unmarshal(data []byte, m proto.Message) error
+}
+
+const (
+	SubprotocolJSON     = "goim.json"
+	SubprotocolProtobuf = "goim.protobuf"
+)
+
+var (
+	JSONCodec     Codec = jsonCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+
+	codecLock sync.RWMutex
+	codecs    = map[string]Codec{
+		SubprotocolJSON:     JSONCodec,
+		SubprotocolProtobuf: ProtobufCodec,
+	}
+	// subprotocols keeps the order of registered codecs, server prefers the former one.
+	subprotocols = []string{SubprotocolJSON, SubprotocolProtobuf}
+)
+
+// RegisterCodec registers codec, it replaces the codec with same name.
+func RegisterCodec(c Codec) {
+	codecLock.Lock()
+	defer codecLock.Unlock()
+
+	if _, ok := codecs[c.Name()]; !ok {
+		subprotocols = append(subprotocols, c.Name())
+	}
+	codecs[c.Name()] = c
+}
+
+// GetCodec returns codec registered with name, it returns JSONCodec if not found.
+func GetCodec(name string) Codec {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+
+	if c, ok := codecs[name]; ok {
+		return c
+	}
+
+	return JSONCodec
+}
+
+// Subprotocols returns names of all registered codecs.
+// Set it to websocket.Upgrader.Subprotocols to negotiate codec with client.
+func Subprotocols() []string {
+	codecLock.RLock()
+	defer codecLock.RUnlock()
+
+	return append([]string(nil), subprotocols...)
+}
+
+// jsonCodec encodes messages with protojson in text frames.
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return SubprotocolJSON
+}
+
+func (jsonCodec) FrameType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) Marshal(m proto.Message) ([]byte, error) {
+	o := protojson.MarshalOptions{EmitUnpopulated: true, UseEnumNumbers: true}
+	return o.Marshal(m)
+}
+
+func (jsonCodec) Unmarshal(data []byte, m proto.Message) error {
+	o := protojson.UnmarshalOptions{DiscardUnknown: true}
+	return o.Unmarshal(data, m)
+}
+
+// protobufCodec encodes messages with protobuf wire format in binary frames.
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string {
+	return SubprotocolProtobuf
+}
+
+func (protobufCodec) FrameType() int {
+	return websocket.BinaryMessage
+}
+
+func (protobufCodec) Marshal(m proto.Message) ([]byte, error) {
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, m proto.Message) error {
+	o := proto.UnmarshalOptions{DiscardUnknown: true}
+	return o.Unmarshal(data, m)
+}
@@ -0,0 +1,92 @@
+package ws
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+
+	"go.uber.org/atomic"
+
+	"github.com/go-goim/core/pkg/log"
+)
+
+// defaultCompressionThreshold is the min payload size to compress, small frames gain little
+// from deflate but cost cpu and memory of flate writer.
+const defaultCompressionThreshold = 256
+
+var (
+	errNotHijacker = errors.New("websocket: response does not implement http.Hijacker")
+)
+
+// setupCompression applies compression level of o, it takes effect only if permessage-deflate
+// is negotiated, see WithCompression.
+func (w *WebsocketConn) setupCompression(o *options) {
+	w.compressionThreshold = o.compressionThreshold
+	w.wire, _ = w.conn.UnderlyingConn().(*countingConn)
+	if o.compressionLevel == 0 {
+		return
+	}
+
+	if err := w.conn.SetCompressionLevel(o.compressionLevel); err != nil {
+		log.Error("websocket set compression level error", "key", w.Key(), "level", o.compressionLevel, "error", err)
+	}
+}
+
+// enableCompression decides whether a frame of size n is compressed, must be called before writing.
+func (w *WebsocketConn) enableCompression(n int) {
+	w.conn.EnableWriteCompression(n >= w.compressionThreshold)
+}
+
+// wireBytes returns bytes written to underlying connection, false if it is not counted.
+func (w *WebsocketConn) wireBytes() (uint64, bool) {
+	if w.wire == nil {
+		return 0, false
+	}
+
+	return w.wire.written.Load(), true
+}
+
+// countingConn counts bytes written to client, so that compression ratio can be measured.
+type countingConn struct {
+	net.Conn
+	written atomic.Uint64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.written.Add(uint64(n))
+	return n, err
+}
+
+// countingResponseWriter wraps hijacked connection with countingConn.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	conn *countingConn
+}
+
+func (rw *countingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errNotHijacker
+	}
+
+	c, brw, err := h.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rw.conn = &countingConn{Conn: c}
+	return rw.conn, brw, nil
+}
+
+// BytesSaved returns payload bytes minus bytes on the wire. Frame headers and control frames
+// are counted on the wire, so it is a little less than the bytes saved by compression,
+// and it is negative if nothing is compressed.
+func (s ConnStats) BytesSaved() int64 {
+	if s.WireBytes == 0 {
+		return 0
+	}
+
+	return int64(s.PayloadBytes) - int64(s.WireBytes)
+}
@@ -0,0 +1,209 @@
+package ws
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-goim/core/pkg/log"
+	"github.com/go-goim/core/pkg/types"
+)
+
+// Conn is a client connection of any transport, it is implemented by WebsocketConn, SSEConn and PollConn.
+// Pool, rooms and observers work with Conn, so pushing to a user does not care about the transport.
+type Conn interface {
+	// Key returns uid if device is not set, otherwise returns uid and device joined by colon.
+	Key() string
+	UID() types.ID
+	Device() string
+	Platform() Platform
+	// Write writes data frame encoded by the connection codec.
+	Write(data []byte) error
+	// WriteContext is same as Write, ctx limits the time waiting for outbound queue.
+	WriteContext(ctx context.Context, data []byte) error
+	Close() error
+	// Err returns why the connection is closed, nil if it is still alive.
+	Err() error
+	// Done is closed when the connection is closed.
+	Done() <-chan struct{}
+	// AddCloseAction adds f to be called once the connection is closed.
+	AddCloseAction(f func() error)
+}
+
+// pooledConn is a Conn kept in pool, each transport closes and removes it from pool in its own way.
+type pooledConn interface {
+	Conn
+	// unwrap returns the Conn exposed to users.
+	unwrap() Conn
+	// stop closes the connection and removes it from pool, it never blocks.
+	stop()
+	// kick closes the connection replaced by the new connection by.
+	kick(by Conn)
+	// reconnect asks client to reconnect to target, target is empty if not specified.
+	reconnect(target string)
+	// drain flushes outbound queue and closes the connection, it never blocks.
+	drain(reason string)
+}
+
+var (
+	_ Conn       = (*WebsocketConn)(nil)
+	_ pooledConn = (*idleConn)(nil)
+	_ pooledConn = (*SSEConn)(nil)
+	_ pooledConn = (*PollConn)(nil)
+)
+
+// connBase is the transport independent part of connections.
+type connBase struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	uid       types.ID
+	device    string
+	platform  Platform
+	observers []Observer
+	connected bool // set if added to pool, only connected conn notifies OnClose
+
+	closeLock    sync.Mutex
+	closed       bool
+	closeActions []func() error
+	// connecting is set while OnConnect is being notified, OnClose is deferred to after it by closePending.
+	connecting   bool
+	closePending bool
+
+	errLock sync.Mutex
+	err     error
+}
+
+func newConnBase(ctx context.Context, uid types.ID, o *options) connBase {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return connBase{
+		ctx:       ctx,
+		cancel:    cancel,
+		uid:       uid,
+		device:    o.device,
+		platform:  o.platform,
+		observers: o.observers,
+	}
+}
+
+func (b *connBase) Key() string {
+	return makeKey(b.uid, b.device)
+}
+
+func (b *connBase) UID() types.ID {
+	return b.uid
+}
+
+func (b *connBase) Device() string {
+	return b.device
+}
+
+func (b *connBase) Platform() Platform {
+	return b.platform
+}
+
+func (b *connBase) Err() error {
+	b.errLock.Lock()
+	err := b.err
+	b.errLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if b.ctx.Err() != nil {
+		return b.ctx.Err()
+	}
+
+	return nil
+}
+
+func (b *connBase) Done() <-chan struct{} {
+	return b.ctx.Done()
+}
+
+// AddCloseAction adds f to be called once the connection is closed, no matter closed by client,
+// by server or by heartbeat timeout. f is called immediately if the connection is already closed.
+func (b *connBase) AddCloseAction(f func() error) {
+	b.closeLock.Lock()
+	if !b.closed {
+		b.closeActions = append(b.closeActions, f)
+		b.closeLock.Unlock()
+		return
+	}
+	b.closeLock.Unlock()
+
+	if err := f(); err != nil {
+		log.Error("conn close action error", "key", b.Key(), "error", err)
+	}
+}
+
+// runCloseActions notifies OnClose with c and runs close actions, only the first call works.
+func (b *connBase) runCloseActions(c Conn) {
+	b.closeLock.Lock()
+	if b.closed {
+		b.closeLock.Unlock()
+		return
+	}
+	b.closed = true
+	actions := b.closeActions
+	b.closeActions = nil
+	notifyClose := b.connected && !b.connecting
+	b.closePending = b.connecting
+	b.closeLock.Unlock()
+
+	if notifyClose {
+		b.notifyClose(c)
+	}
+
+	for _, f := range actions {
+		if err := f(); err != nil {
+			log.Error("conn close action error", "key", b.Key(), "error", err)
+		}
+	}
+}
+
+func (b *connBase) notifyClose(c Conn) {
+	b.errLock.Lock()
+	reason := b.err
+	b.errLock.Unlock()
+	b.notify(func(o Observer) { o.OnClose(c, reason) })
+}
+
+// connect adds c to pool and notifies OnConnect if succeeded. Observers are notified without close lock,
+// so they may close c or add close actions; OnClose of c closed meanwhile is notified after OnConnect.
+func (b *connBase) connect(c pooledConn) error {
+	b.closeLock.Lock()
+	if err := dp.add(c); err != nil {
+		b.closeLock.Unlock()
+		return err
+	}
+	b.connected = true
+	b.connecting = true
+	b.closeLock.Unlock()
+
+	countConnect(c.unwrap())
+	b.notify(func(o Observer) { o.OnConnect(c.unwrap()) })
+
+	b.closeLock.Lock()
+	b.connecting = false
+	pending := b.closePending
+	b.closeLock.Unlock()
+
+	if pending {
+		b.notifyClose(c.unwrap())
+	}
+
+	return nil
+}
+
+// cancelWithError cancels the connection context and keeps the first error as the reason.
+func (b *connBase) cancelWithError(e error) {
+	b.errLock.Lock()
+	if b.err == nil && b.ctx.Err() == nil {
+		b.err = e
+	}
+	b.errLock.Unlock()
+	b.cancel()
+}
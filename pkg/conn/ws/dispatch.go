@@ -0,0 +1,99 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/go-goim/core/pkg/log"
+)
+
+// MessageHandler handles data frames read from client.
+// Frames of one connection are handled one by one in the order they are read.
+// Frames posted to http connections are always text frames, see Upgrader.ServePost.
+type MessageHandler interface {
+	HandleMessage(ctx context.Context, c Conn, mt int, data []byte) error
+}
+
+// MessageHandlerFunc is an adapter to allow the use of ordinary functions as MessageHandler.
+type MessageHandlerFunc func(ctx context.Context, c Conn, mt int, data []byte) error
+
+func (f MessageHandlerFunc) HandleMessage(ctx context.Context, c Conn, mt int, data []byte) error {
+	return f(ctx, c, mt, data)
+}
+
+var (
+	defaultMessageHandler MessageHandler
+)
+
+// SetMessageHandler sets the default handler for connections wrapped after this call.
+// Connections without any handler only log the frames they read.
+func SetMessageHandler(h MessageHandler) {
+	defaultMessageHandler = h
+}
+
+// CloseError is returned by MessageHandler to close the connection with given close code.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func NewCloseError(code int, text string) *CloseError {
+	return &CloseError{
+		Code: code,
+		Text: text,
+	}
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed by handler, code=%d, text=%s", e.Code, e.Text)
+}
+
+type frame struct {
+	mt   int
+	data []byte
+}
+
+// dispatchDaemon passes frames read by readDaemon to message handler.
+func (w *WebsocketConn) dispatchDaemon() {
+	for {
+		select {
+		case <-w.ctx.Done():
+			return
+		case f := <-w.readChan:
+			w.dispatch(f)
+		}
+	}
+}
+
+func (w *WebsocketConn) dispatch(f *frame) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("websocket handle message panic", "uid", w.uid, "panic", r)
+		}
+	}()
+
+	err := w.handler.HandleMessage(w.ctx, w, f.mt, f.data)
+	if err == nil {
+		return
+	}
+
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		log.Info("websocket closed by handler", "uid", w.uid, "code", ce.Code, "text", ce.Text)
+		w.closeWithCode(ce.Code, ce.Text, ce)
+		return
+	}
+
+	log.Error("websocket handle message error", "uid", w.uid, "mt", f.mt, "error", err)
+}
+
+// closeWithCode sends close frame to client and stops the connection.
+func (w *WebsocketConn) closeWithCode(code int, text string, e error) {
+	message := websocket.FormatCloseMessage(code, text)
+	_ = w.conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	w.cancelWithError(e)
+}
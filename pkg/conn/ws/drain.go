@@ -0,0 +1,178 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-goim/core/pkg/graceful"
+	"github.com/go-goim/core/pkg/log"
+)
+
+var (
+	ErrDraining = errors.New("websocket server draining")
+)
+
+const (
+	defaultDrainBatchSize = 100
+	defaultDrainInterval  = 100 * time.Millisecond
+	// drainSpread is the part of shutdown deadline used to spread batches,
+	// the rest is left for flushing and closing the last batch.
+	drainSpread = 0.8
+	// maxCloseReasonSize is max control frame payload minus 2 bytes close code.
+	maxCloseReasonSize = 123
+)
+
+var drainOpts []DrainOption
+
+func init() {
+	graceful.RegisterPhase(graceful.PhaseDrain, func(ctx context.Context) error {
+		return Drain(ctx, drainOpts...)
+	})
+}
+
+type drainOptions struct {
+	target    string
+	batchSize int
+	interval  time.Duration
+}
+
+type DrainOption func(o *drainOptions)
+
+// WithDrainTarget sets the server address clients are suggested to reconnect to.
+func WithDrainTarget(target string) DrainOption {
+	return func(o *drainOptions) {
+		o.target = target
+	}
+}
+
+// WithDrainBatch sets how many connections are closed in a batch and the interval between batches.
+// Zero interval spreads batches over the shutdown deadline.
+func WithDrainBatch(size int, interval time.Duration) DrainOption {
+	return func(o *drainOptions) {
+		if size > 0 {
+			o.batchSize = size
+		}
+		o.interval = interval
+	}
+}
+
+// SetDrainOptions sets options of Drain called on graceful shutdown.
+func SetDrainOptions(opts ...DrainOption) {
+	drainOpts = opts
+}
+
+// Draining reports whether the pool is draining, new connections are rejected then.
+func Draining() bool {
+	return dp.draining.Load()
+}
+
+// Drain stops accepting new connections, asks all clients to reconnect to another server,
+// and then closes connections in paced batches, pending frames in outbound queue are flushed before close.
+// Websocket clients get KindReconnect envelope and then close frame with websocket.CloseServiceRestart.
+// Connections not closed before ctx is done are closed at once.
+// Drain is registered to graceful.PhaseDrain, see SetDrainOptions.
+func Drain(ctx context.Context, opts ...DrainOption) error {
+	o := &drainOptions{
+		batchSize: defaultDrainBatchSize,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return dp.drain(ctx, o)
+}
+
+func (p *namedPool) drain(ctx context.Context, o *drainOptions) error {
+	if !p.draining.CAS(false, true) {
+		return nil
+	}
+
+	conns := p.pooledSnapshot()
+	log.Info("websocket pool draining", "count", len(conns), "target", o.target)
+
+	for _, i := range conns {
+		i.reconnect(o.target)
+	}
+
+	// close reason carries target too, if it fits in close frame
+	reason := o.target
+	if len(reason) > maxCloseReasonSize {
+		reason = ""
+	}
+
+	interval := drainInterval(ctx, o, len(conns))
+	for start := 0; start < len(conns); start += o.batchSize {
+		end := start + o.batchSize
+		if end > len(conns) {
+			end = len(conns)
+		}
+
+		if start > 0 && !sleepContext(ctx, interval) {
+			break
+		}
+
+		for _, i := range conns[start:end] {
+			i.drain(reason)
+		}
+		for _, i := range conns[start:end] {
+			select {
+			case <-i.Done():
+			case <-ctx.Done():
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		for _, i := range conns {
+			_ = i.Close()
+			i.stop()
+		}
+		return err
+	}
+
+	return nil
+}
+
+// drainInterval returns interval between batches, it spreads batches over the deadline of ctx
+// if interval is not set.
+func drainInterval(ctx context.Context, o *drainOptions, n int) time.Duration {
+	if o.interval > 0 {
+		return o.interval
+	}
+
+	batches := (n + o.batchSize - 1) / o.batchSize
+	deadline, ok := ctx.Deadline()
+	if !ok || batches <= 1 {
+		return defaultDrainInterval
+	}
+
+	return time.Duration(float64(time.Until(deadline)) * drainSpread / float64(batches))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// pooledSnapshot is same as snapshot, but returns pooledConn.
+func (p *namedPool) pooledSnapshot() []pooledConn {
+	conns := make([]pooledConn, 0, p.count())
+	for _, s := range p.shards {
+		s.RLock()
+		for _, devices := range s.m {
+			for _, i := range devices {
+				conns = append(conns, i)
+			}
+		}
+		s.RUnlock()
+	}
+
+	return conns
+}
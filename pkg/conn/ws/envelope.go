@@ -0,0 +1,98 @@
+package ws
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+
+	"github.com/gorilla/websocket"
+)
+
+// EnvelopeKind is the kind of Envelope.
+type EnvelopeKind uint8
+
+const (
+	// KindData carries payload with sequence.
+	KindData EnvelopeKind = iota + 1
+	// KindAck is sent by client, it acknowledges all data frames with seq less or equal than Seq.
+	KindAck
+	// KindResync is sent by server after resume if frames after client last seq are lost,
+	// client should sync from offline messages. Seq is the first seq server can replay.
+	KindResync
+	// KindWarn is sent by server when client violates inbound limits, Seq is the violation count.
+	// Client keeps violating will be throttled and then disconnected.
+	KindWarn
+	// KindReconnect is sent by server before it shuts down, client should reconnect to another server.
+	// Payload is the suggested server address if any, it is a json string in text frame.
+	KindReconnect
+)
+
+// Envelope wraps data frames when ack is enabled, and control frames sent by server.
+//
+// Envelope in text frame is json:
+//
+//	{"kind":1,"seq":1,"payload":{...}}
+//
+// and payload must be valid json. Envelope in binary frame is:
+//
+//	1 byte kind | 8 bytes big endian seq | payload
+type Envelope struct {
+	Kind    EnvelopeKind
+	Seq     uint64
+	Payload []byte
+}
+
+type jsonEnvelope struct {
+	Kind    EnvelopeKind    `json:"kind"`
+	Seq     uint64          `json:"seq,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+const envelopeHeaderSize = 9
+
+var (
+	ErrInvalidEnvelope = errors.New("invalid envelope")
+)
+
+// EncodeEnvelope encodes env for frame type mt.
+func EncodeEnvelope(mt int, env *Envelope) ([]byte, error) {
+	if mt == websocket.TextMessage {
+		if len(env.Payload) > 0 && !json.Valid(env.Payload) {
+			return nil, ErrInvalidEnvelope
+		}
+
+		return json.Marshal(&jsonEnvelope{
+			Kind:    env.Kind,
+			Seq:     env.Seq,
+			Payload: env.Payload,
+		})
+	}
+
+	b := make([]byte, envelopeHeaderSize+len(env.Payload))
+	b[0] = byte(env.Kind)
+	binary.BigEndian.PutUint64(b[1:envelopeHeaderSize], env.Seq)
+	copy(b[envelopeHeaderSize:], env.Payload)
+	return b, nil
+}
+
+// DecodeEnvelope decodes data read in frame type mt.
+func DecodeEnvelope(mt int, data []byte) (*Envelope, error) {
+	if mt == websocket.TextMessage {
+		je := new(jsonEnvelope)
+		if err := json.Unmarshal(data, je); err != nil {
+			return nil, ErrInvalidEnvelope
+		}
+
+		return &Envelope{Kind: je.Kind, Seq: je.Seq, Payload: je.Payload}, nil
+	}
+
+	if len(data) < envelopeHeaderSize {
+		return nil, ErrInvalidEnvelope
+	}
+
+	return &Envelope{
+		Kind:    EnvelopeKind(data[0]),
+		Seq:     binary.BigEndian.Uint64(data[1:envelopeHeaderSize]),
+		Payload: data[envelopeHeaderSize:],
+	}, nil
+}
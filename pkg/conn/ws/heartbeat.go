@@ -0,0 +1,52 @@
+package ws
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	ErrIdleTimeout = errors.New("websocket idle timeout")
+)
+
+// touch marks the connection active.
+func (w *WebsocketConn) touch() {
+	w.lastActive.Store(time.Now().UnixNano())
+}
+
+// extendReadDeadline is called when any frame is read from client.
+func (w *WebsocketConn) extendReadDeadline() {
+	if w.pongWait <= 0 {
+		return
+	}
+
+	_ = w.conn.SetReadDeadline(time.Now().Add(w.pongWait))
+}
+
+// heartbeatInterval returns how often the daemon checks the connection, zero means never.
+func (w *WebsocketConn) heartbeatInterval() time.Duration {
+	if w.pingInterval > 0 {
+		return w.pingInterval
+	}
+
+	return w.maxIdle
+}
+
+// heartbeat pings client and closes the connection if it is idle for too long.
+func (w *WebsocketConn) heartbeat() {
+	if w.maxIdle > 0 && time.Since(time.Unix(0, w.lastActive.Load())) > w.maxIdle {
+		w.closeWithCode(websocket.CloseGoingAway, "idle timeout", ErrIdleTimeout)
+		return
+	}
+
+	if w.pingInterval <= 0 {
+		return
+	}
+
+	err := w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+	if err != nil && err != websocket.ErrCloseSent {
+		w.cancelWithError(err)
+	}
+}
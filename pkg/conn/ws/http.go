@@ -0,0 +1,251 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.uber.org/atomic"
+
+	"github.com/go-goim/core/pkg/log"
+	"github.com/go-goim/core/pkg/types"
+)
+
+var (
+	ErrNotJSON      = errors.New("http conn only accepts json frames")
+	ErrConnNotFound = errors.New("http conn not found")
+)
+
+// names of control events sent by http connections.
+const (
+	reconnectEvent = "reconnect"
+	closeEvent     = "close"
+)
+
+// event is queued in http connections, name is empty for data frames.
+type event struct {
+	name string
+	data []byte
+}
+
+// httpConn is the common part of SSEConn and PollConn. Frames to client are queued and written
+// by http handlers of the transport, frames from client are posted by Upgrader.ServePost.
+// Frames are json encoded by JSONCodec, since http transports are text only.
+type httpConn struct {
+	connBase
+	self         pooledConn // the transport conn, passed to handler and observers
+	queue        chan *event
+	writeTimeout time.Duration
+	readLimit    int64
+	handler      MessageHandler
+	postLock     sync.Mutex // posted frames are handled one by one
+	written      atomic.Uint64
+	dropped      atomic.Uint64
+}
+
+// minHTTPQueueSize is the min queue size of http connections, frames are kept in queue between polls.
+const minHTTPQueueSize = 64
+
+func newHTTPConn(uid types.ID, o *options) *httpConn {
+	size := o.writeQueueSize
+	if size < minHTTPQueueSize {
+		size = minHTTPQueueSize
+	}
+
+	return &httpConn{
+		connBase:     newConnBase(context.Background(), uid, o),
+		queue:        make(chan *event, size),
+		writeTimeout: o.writeTimeout,
+		readLimit:    o.readLimit,
+		handler:      o.handler,
+	}
+}
+
+func (h *httpConn) unwrap() Conn {
+	return h.self
+}
+
+func (h *httpConn) Write(data []byte) error {
+	return h.WriteContext(context.Background(), data)
+}
+
+// WriteContext puts data into queue, it waits for write timeout or until ctx is done if queue is full,
+// and drops data then.
+func (h *httpConn) WriteContext(ctx context.Context, data []byte) error {
+	if err := h.Err(); err != nil {
+		return err
+	}
+
+	if !json.Valid(data) {
+		return ErrNotJSON
+	}
+
+	return h.enqueue(ctx, &event{data: data})
+}
+
+func (h *httpConn) enqueue(ctx context.Context, e *event) error {
+	select {
+	case h.queue <- e:
+		return nil
+	default:
+	}
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	timer := time.NewTimer(h.writeTimeout)
+	defer timer.Stop()
+	select {
+	case h.queue <- e:
+		return nil
+	case <-timer.C:
+		h.dropped.Inc()
+		return ErrWriteChanFull
+	case <-ctx.Done():
+		h.dropped.Inc()
+		return ctx.Err()
+	case <-h.ctx.Done():
+		return h.Err()
+	}
+}
+
+// Close closes the connection and removes it from pool.
+func (h *httpConn) Close() error {
+	h.cancel()
+	h.runCloseActions(h.self)
+	dp.delete(h.self)
+	return nil
+}
+
+// Stats returns statistics of the connection.
+func (h *httpConn) Stats() ConnStats {
+	return ConnStats{
+		QueueSize:  cap(h.queue),
+		QueueDepth: len(h.queue),
+		Written:    h.written.Load(),
+		Dropped:    h.dropped.Load(),
+	}
+}
+
+// stop cancels the connection, handler of the transport closes it then.
+func (h *httpConn) stop() {
+	h.cancel()
+}
+
+func (h *httpConn) kick(by Conn) {
+	if h.Err() == nil {
+		log.Info("conn kicked", "key", h.Key(), "by", by.Key())
+		h.notify(func(o Observer) { o.OnKicked(h.self, by) })
+		h.cancelWithError(ErrKicked)
+	}
+}
+
+func (h *httpConn) reconnect(target string) {
+	select {
+	case h.queue <- &event{name: reconnectEvent, data: []byte(target)}:
+	default:
+	}
+}
+
+// drain cancels the connection, frames left in queue are still delivered by handler of the transport.
+func (h *httpConn) drain(string) {
+	h.cancelWithError(ErrDraining)
+}
+
+// accept adds h to pool, and replies http error if it is rejected.
+func (h *httpConn) accept(rw http.ResponseWriter) bool {
+	err := h.connect(h.self)
+	if err == nil {
+		return true
+	}
+
+	log.Info("http conn rejected", "key", h.Key(), "error", err)
+	status := http.StatusConflict
+	if err == ErrDraining {
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(rw, err.Error(), status)
+	h.cancelWithError(err)
+	return false
+}
+
+// pending returns events left in queue.
+func (h *httpConn) pending() []*event {
+	var events []*event
+	for {
+		select {
+		case e := <-h.queue:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+// post passes data posted by client to message handler.
+func (h *httpConn) post(data []byte) error {
+	if h.handler == nil {
+		log.Info("http conn post message", "uid", h.uid, "message", string(data))
+		return nil
+	}
+
+	h.postLock.Lock()
+	err := h.handler.HandleMessage(h.ctx, h.self, websocket.TextMessage, data)
+	h.postLock.Unlock()
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		log.Info("http conn closed by handler", "uid", h.uid, "code", ce.Code, "text", ce.Text)
+		h.cancelWithError(ce)
+		return nil
+	}
+
+	return err
+}
+
+// ServePost passes body of request posted by client to message handler of the connection of same key,
+// which is a SSEConn or a PollConn. Client gets 204 if succeeded.
+func (u *Upgrader) ServePost(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := u.authenticate(rw, r)
+	if err != nil {
+		return
+	}
+
+	var h *httpConn
+	switch c := GetByDevice(claims.UserID, headerOrQuery(r, DeviceKey)).(type) {
+	case *SSEConn:
+		h = c.httpConn
+	case *PollConn:
+		h = c.httpConn
+	default:
+		http.Error(rw, ErrConnNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if h.readLimit > 0 {
+		body = http.MaxBytesReader(rw, r.Body, h.readLimit)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if err = h.post(data); err != nil {
+		log.Error("http conn handle message error", "uid", h.uid, "error", err)
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rw.WriteHeader(http.StatusNoContent)
+}
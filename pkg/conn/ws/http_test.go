@@ -0,0 +1,156 @@
+package ws
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+
+	"github.com/go-goim/core/pkg/mid"
+	"github.com/go-goim/core/pkg/types"
+)
+
+func newHTTPTestServer(t *testing.T, opts ...Option) string {
+	u := NewUpgrader(WithConnOptions(opts...))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/sse", u.ServeSSE)
+	mux.HandleFunc("/poll", u.ServePoll)
+	mux.HandleFunc("/post", u.ServePost)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestSSEConn(t *testing.T) {
+	var (
+		uid      = types.ID(5001)
+		received = make(chan string, 1)
+	)
+	h := MessageHandlerFunc(func(ctx context.Context, c Conn, mt int, data []byte) error {
+		received <- c.Key() + ":" + string(data)
+		return nil
+	})
+	addr := newHTTPTestServer(t, WithMessageHandler(h))
+	token, err := mid.NewJwtToken(uid)
+	assert.Nil(t, err)
+	query := "?token=" + token + "&" + DeviceKey + "=web-1"
+
+	resp, err := http.Get(addr + "/sse" + query)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	var c Conn
+	assert.Eventually(t, func() bool { c = GetByDevice(uid, "web-1"); return c != nil }, time.Second, 10*time.Millisecond)
+	_, ok := c.(*SSEConn)
+	assert.True(t, ok)
+
+	assert.Equal(t, ErrNotJSON, c.Write([]byte("not json")))
+	assert.Nil(t, c.Write([]byte(`{"content":"hello"}`)))
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "data: {\"content\":\"hello\"}\n", line)
+
+	post, err := http.Post(addr+"/post"+query, "application/json", strings.NewReader(`"hi"`))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, post.StatusCode)
+	assert.Equal(t, c.Key()+`:"hi"`, <-received)
+
+	assert.Nil(t, c.Close())
+	_, _ = r.ReadString('\n')
+	line, _ = r.ReadString('\n')
+	assert.Equal(t, "event: close\n", line)
+	assert.Nil(t, GetByDevice(uid, "web-1"))
+}
+
+func TestPollConn(t *testing.T) {
+	uid := types.ID(5002)
+	addr := newHTTPTestServer(t, WithPollTimeout(50*time.Millisecond))
+	token, err := mid.NewJwtToken(uid)
+	assert.Nil(t, err)
+	url := addr + "/poll?token=" + token
+
+	poll := func() *pollResponse {
+		resp, err := http.Get(url)
+		assert.Nil(t, err)
+		defer resp.Body.Close()
+		pr := new(pollResponse)
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(pr))
+		return pr
+	}
+
+	// no frames within poll timeout
+	assert.Len(t, poll().Frames, 0)
+	c := Get(uid.String())
+	assert.NotNil(t, c)
+
+	assert.Nil(t, c.Write([]byte(`1`)))
+	assert.Nil(t, c.Write([]byte(`2`)))
+	pr := poll()
+	assert.Equal(t, []json.RawMessage{json.RawMessage("1"), json.RawMessage("2")}, pr.Frames)
+
+	// closed if client does not poll again
+	assert.Eventually(t, func() bool { return c.Err() == ErrPollTimeout }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return Get(uid.String()) == nil }, time.Second, 10*time.Millisecond)
+}
+
+type connectCounter struct {
+	BaseObserver
+	n atomic.Int32
+}
+
+func (c *connectCounter) OnConnect(Conn) { c.n.Inc() }
+
+func TestPollConn_ConcurrentFirstPoll(t *testing.T) {
+	uid := types.ID(5003)
+	counter := &connectCounter{}
+	// slow creating widens the window between looking up and adding conn
+	slow := func(*options) { time.Sleep(50 * time.Millisecond) }
+	addr := newHTTPTestServer(t, WithPollTimeout(200*time.Millisecond), WithObserver(counter), slow)
+	token, err := mid.NewJwtToken(uid)
+	assert.Nil(t, err)
+	url := addr + "/poll?token=" + token + "&" + DeviceKey + "=web-1"
+
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		frames []json.RawMessage
+	)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Get(url)
+			if !assert.Nil(t, err) {
+				return
+			}
+			defer resp.Body.Close()
+			pr := new(pollResponse)
+			assert.Nil(t, json.NewDecoder(resp.Body).Decode(pr))
+			assert.Empty(t, pr.Close)
+			lock.Lock()
+			frames = append(frames, pr.Frames...)
+			lock.Unlock()
+		}()
+	}
+
+	var c Conn
+	assert.Eventually(t, func() bool { c = GetByDevice(uid, "web-1"); return c != nil }, time.Second, 10*time.Millisecond)
+	assert.Nil(t, c.Write([]byte(`1`)))
+	wg.Wait()
+
+	// both polls are served by the same connection and frame is not lost
+	assert.Equal(t, int32(1), counter.n.Load())
+	assert.Nil(t, c.Err())
+	assert.Equal(t, []json.RawMessage{json.RawMessage("1")}, frames)
+	assert.Nil(t, c.Close())
+}
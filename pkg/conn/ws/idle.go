@@ -1,6 +1,11 @@
 package ws
 
 import (
+	"encoding/json"
+	"time"
+
+	"github.com/gorilla/websocket"
+
 	"github.com/go-goim/core/pkg/log"
 )
 
@@ -8,6 +13,8 @@ type idleConn struct {
 	*WebsocketConn
 	p        *namedPool
 	stopChan chan struct{}
+	// drainChan receives close reason when pool is draining
+	drainChan chan string
 }
 
 // close is different form stop
@@ -15,14 +22,68 @@ type idleConn struct {
 // stop is a trigger to stop the daemon then call the close
 func (i *idleConn) close() {
 	_ = i.Close()
-	i.p.delete(i.Key())
+	i.p.delete(i)
 }
 
+// stop never blocks, it is safe to call stop more than once or after daemon exited.
 func (i *idleConn) stop() {
-	i.stopChan <- struct{}{}
+	select {
+	case i.stopChan <- struct{}{}:
+	default:
+	}
+}
+
+// drain flushes outbound queue and closes the connection with websocket.CloseServiceRestart.
+// It never blocks like stop.
+func (i *idleConn) drain(reason string) {
+	select {
+	case i.drainChan <- reason:
+	default:
+	}
+}
+
+// reconnect sends KindReconnect envelope, target is json string in text frame.
+func (i *idleConn) reconnect(target string) {
+	env := &Envelope{Kind: KindReconnect, Payload: []byte(target)}
+	if i.codec.FrameType() == websocket.TextMessage && target != "" {
+		env.Payload, _ = json.Marshal(target)
+	}
+	i.writeEnvelope(env)
+}
+
+// flush writes frames left in outbound queue.
+func (i *idleConn) flush() {
+	for i.Err() == nil {
+		select {
+		case f := <-i.writeChan:
+			i.writeToClient(f)
+		default:
+			return
+		}
+	}
+}
+
+func (i *idleConn) unwrap() Conn {
+	return i.WebsocketConn
+}
+
+func (i *idleConn) kick(by Conn) {
+	if i.Err() == nil {
+		log.Info("conn kicked", "key", i.Key(), "by", by.Key())
+		i.notify(func(o Observer) { o.OnKicked(i.WebsocketConn, by) })
+		i.closeWithCode(CloseKicked, "kicked by new connection", ErrKicked)
+	}
+	i.stop()
 }
 
 func (i *idleConn) daemon() {
+	var heartbeat <-chan time.Time
+	if d := i.heartbeatInterval(); d > 0 {
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
 loop:
 	for {
 		select {
@@ -32,8 +93,15 @@ loop:
 		case <-i.stopChan:
 			log.Info("conn stop", "key", i.Key())
 			break loop
+		case reason := <-i.drainChan:
+			log.Info("conn drain", "key", i.Key())
+			i.flush()
+			i.closeWithCode(websocket.CloseServiceRestart, reason, ErrDraining)
+			break loop
 		case data := <-i.writeChan:
 			i.writeToClient(data)
+		case <-heartbeat:
+			i.heartbeat()
 		}
 	}
 
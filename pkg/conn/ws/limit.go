@@ -0,0 +1,150 @@
+package ws
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/go-goim/core/pkg/log"
+)
+
+// ViolationKind is the kind of inbound limit a client violated.
+type ViolationKind int
+
+const (
+	// ViolationRate means client sends frames faster than the rate limit, see WithRateLimit.
+	ViolationRate ViolationKind = iota + 1
+	// ViolationSize means client sends a frame larger than the read limit, see WithReadLimit.
+	ViolationSize
+)
+
+// ViolationAction is what the connection does on a violation, it escalates with violation count.
+type ViolationAction int
+
+const (
+	// ActionWarn sends KindWarn envelope to client, the frame is still handled.
+	ActionWarn ViolationAction = iota + 1
+	// ActionThrottle pauses reading until the rate limit allows the frame.
+	ActionThrottle
+	// ActionClose closes the connection with websocket.ClosePolicyViolation.
+	// Connection violated read limit is always closed with websocket.CloseMessageTooBig.
+	ActionClose
+)
+
+// Violation is reported to observers every time client violates inbound limits.
+type Violation struct {
+	Kind   ViolationKind
+	Action ViolationAction
+	Count  int // violations in current window, including this one
+}
+
+var (
+	ErrRateLimited = errors.New("websocket rate limit exceeded")
+)
+
+// violationWindow is how long a connection must behave to get its violation count reset.
+var violationWindow = time.Minute
+
+// tokenBucket is only used by readDaemon, so it is not safe for concurrent use.
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// take takes a token and returns zero, or returns how long to wait for next token if no token left.
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+// limitRate is called by readDaemon for every frame, it returns false if the connection is closed.
+func (w *WebsocketConn) limitRate() bool {
+	if w.limiter == nil {
+		return true
+	}
+
+	wait := w.limiter.take(time.Now())
+	if wait == 0 {
+		return true
+	}
+
+	switch w.violate(ViolationRate) {
+	case ActionWarn:
+		w.warn()
+		return true
+	case ActionThrottle:
+		for wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-w.ctx.Done():
+				timer.Stop()
+				return false
+			}
+			wait = w.limiter.take(time.Now())
+		}
+		return true
+	default:
+		log.Warn("websocket rate limit exceeded", "key", w.Key(), "violations", w.violationCount)
+		w.closeWithCode(websocket.ClosePolicyViolation, "rate limit exceeded", ErrRateLimited)
+		return false
+	}
+}
+
+// violate counts a violation of kind, notifies observers and returns the escalated action.
+func (w *WebsocketConn) violate(kind ViolationKind) ViolationAction {
+	now := time.Now()
+	if now.Sub(w.lastViolation) > violationWindow {
+		w.violationCount = 0
+	}
+	w.lastViolation = now
+	w.violationCount++
+	w.violations.Inc()
+
+	var action ViolationAction
+	switch {
+	case kind == ViolationSize:
+		action = ActionClose
+	case w.violationCount <= w.warnViolations:
+		action = ActionWarn
+	case w.violationCount <= w.closeViolations:
+		action = ActionThrottle
+	default:
+		action = ActionClose
+	}
+
+	v := Violation{Kind: kind, Action: action, Count: w.violationCount}
+	w.notify(func(o Observer) { o.OnViolation(w, v) })
+	return action
+}
+
+// warn sends KindWarn envelope to client.
+func (w *WebsocketConn) warn() {
+	w.writeEnvelope(&Envelope{Kind: KindWarn, Seq: uint64(w.violationCount)})
+}
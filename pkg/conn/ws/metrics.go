@@ -0,0 +1,111 @@
+package ws
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/go-goim/core/pkg/metrics"
+	"github.com/go-goim/core/pkg/types"
+)
+
+var (
+	registerOnce sync.Once
+
+	connectsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "ws",
+		Name:      "connects_total",
+		Help:      "Total number of connections added to pool.",
+	}, []string{"transport"})
+
+	poolMetrics = &poolCollector{
+		conns: prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "ws", "connections"),
+			"Number of connections in pool.", []string{"transport", "platform"}, nil),
+		users: prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "ws", "users"),
+			"Number of users having connections in pool.", nil, nil),
+		queueDepth: prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "ws", "outbound_queue_depth"),
+			"Number of frames waiting in outbound queues of all connections.", nil, nil),
+		unacked: prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "ws", "unacked_frames"),
+			"Number of frames not acknowledged by clients.", nil, nil),
+		draining: prometheus.NewDesc(prometheus.BuildFQName(metrics.Namespace, "ws", "draining"),
+			"Whether the pool is draining.", nil, nil),
+	}
+)
+
+// poolCollector reports gauges of default pool, they are computed from a snapshot of pool on scrape.
+type poolCollector struct {
+	conns      *prometheus.Desc
+	users      *prometheus.Desc
+	queueDepth *prometheus.Desc
+	unacked    *prometheus.Desc
+	draining   *prometheus.Desc
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.conns
+	ch <- c.users
+	ch <- c.queueDepth
+	ch <- c.unacked
+	ch <- c.draining
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	type label struct {
+		transport string
+		platform  Platform
+	}
+
+	var (
+		conns             = make(map[label]int)
+		users             = make(map[types.ID]struct{})
+		queueDepth, unack int
+	)
+	dp.rangeShards(func(s []Conn) bool {
+		for _, conn := range s {
+			conns[label{transport: transportOf(conn), platform: conn.Platform()}]++
+			users[conn.UID()] = struct{}{}
+			if sc, ok := conn.(interface{ Stats() ConnStats }); ok {
+				stats := sc.Stats()
+				queueDepth += stats.QueueDepth
+				unack += stats.Unacked
+			}
+		}
+		return true
+	})
+
+	for l, n := range conns {
+		ch <- prometheus.MustNewConstMetric(c.conns, prometheus.GaugeValue, float64(n), l.transport, string(l.platform))
+	}
+	ch <- prometheus.MustNewConstMetric(c.users, prometheus.GaugeValue, float64(len(users)))
+	ch <- prometheus.MustNewConstMetric(c.queueDepth, prometheus.GaugeValue, float64(queueDepth))
+	ch <- prometheus.MustNewConstMetric(c.unacked, prometheus.GaugeValue, float64(unack))
+
+	var draining float64
+	if dp.draining.Load() {
+		draining = 1
+	}
+	ch <- prometheus.MustNewConstMetric(c.draining, prometheus.GaugeValue, draining)
+}
+
+// countConnect counts connection added to pool, collectors are registered on the first connection.
+func countConnect(c Conn) {
+	registerOnce.Do(func() {
+		metrics.Register(connectsTotal, poolMetrics)
+	})
+
+	connectsTotal.WithLabelValues(transportOf(c)).Inc()
+}
+
+func transportOf(c Conn) string {
+	switch c.(type) {
+	case *WebsocketConn:
+		return "websocket"
+	case *SSEConn:
+		return "sse"
+	case *PollConn:
+		return "poll"
+	default:
+		return "unknown"
+	}
+}
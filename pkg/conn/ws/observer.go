@@ -0,0 +1,74 @@
+package ws
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/go-goim/core/pkg/log"
+)
+
+// CloseKicked is the close code sent to connection kicked by a new connection.
+const CloseKicked = 4001
+
+var (
+	ErrKicked = errors.New("websocket kicked by new connection")
+)
+
+// Observer observes lifecycle events of connections. Observers are called synchronously,
+// so they should not block. Embed BaseObserver to only implement part of the events.
+type Observer interface {
+	// OnConnect is called after connection is added to pool.
+	OnConnect(c Conn)
+	// OnClose is called once after connection is closed, reason is nil if closed normally.
+	OnClose(c Conn, reason error)
+	// OnWriteError is called when writing to client failed, the connection is closed then.
+	OnWriteError(c Conn, err error)
+	// OnReadError is called when reading from client failed, the connection is closed then.
+	OnReadError(c Conn, err error)
+	// OnKicked is called when c is replaced by connection by, see SetDevicePolicy.
+	OnKicked(c Conn, by Conn)
+	// OnViolation is called when client violates inbound limits, see WithRateLimit and WithReadLimit.
+	OnViolation(c Conn, v Violation)
+}
+
+// BaseObserver implements Observer with doing nothing.
+type BaseObserver struct{}
+
+var _ Observer = BaseObserver{}
+
+func (BaseObserver) OnConnect(Conn)              {}
+func (BaseObserver) OnClose(Conn, error)         {}
+func (BaseObserver) OnWriteError(Conn, error)    {}
+func (BaseObserver) OnReadError(Conn, error)     {}
+func (BaseObserver) OnKicked(Conn, Conn)         {}
+func (BaseObserver) OnViolation(Conn, Violation) {}
+
+var (
+	observerLock    sync.RWMutex
+	globalObservers []Observer
+)
+
+// RegisterObserver registers observer for all connections.
+func RegisterObserver(o Observer) {
+	observerLock.Lock()
+	defer observerLock.Unlock()
+	globalObservers = append(globalObservers, o)
+}
+
+// notify calls f with global observers and then observers of the connection.
+func (b *connBase) notify(f func(o Observer)) {
+	observerLock.RLock()
+	observers := append(globalObservers[:len(globalObservers):len(globalObservers)], b.observers...)
+	observerLock.RUnlock()
+
+	for _, o := range observers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Error("conn observer panic", "key", b.Key(), "panic", r)
+				}
+			}()
+			f(o)
+		}()
+	}
+}
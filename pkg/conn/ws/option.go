@@ -0,0 +1,218 @@
+package ws
+
+import "time"
+
+type options struct {
+	device          string
+	platform        Platform
+	codec           Codec
+	handler         MessageHandler
+	observers       []Observer
+	handleQueueSize int
+	pingInterval    time.Duration
+	pongWait        time.Duration
+	maxIdle         time.Duration
+	writeQueueSize  int
+	overflow        OverflowPolicy
+	writeTimeout    time.Duration
+	ackBufferSize   int
+	resumeTTL       time.Duration
+	resume          bool
+	lastSeq         uint64
+	readLimit       int64
+	rateLimit       float64
+	rateBurst       int
+	warnViolations  int
+	closeViolations int
+	// compression
+	compressionLevel     int
+	compressionThreshold int
+	pollTimeout          time.Duration
+}
+
+const (
+	defaultHandleQueueSize = 16
+	defaultPingInterval    = 30 * time.Second
+	defaultPongWait        = 75 * time.Second
+	defaultWriteQueueSize  = 1
+	defaultWriteTimeout    = 10 * time.Millisecond
+	defaultResumeTTL       = 2 * time.Minute
+	defaultWarnViolations  = 1
+	defaultCloseViolations = 10
+	defaultPollTimeout     = 30 * time.Second
+)
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		handler:         defaultMessageHandler,
+		handleQueueSize: defaultHandleQueueSize,
+		pingInterval:    defaultPingInterval,
+		pongWait:        defaultPongWait,
+		writeQueueSize:  defaultWriteQueueSize,
+		overflow:        OverflowDropNewest,
+		writeTimeout:    defaultWriteTimeout,
+		warnViolations:  defaultWarnViolations,
+		closeViolations: defaultCloseViolations,
+
+		compressionThreshold: defaultCompressionThreshold,
+		pollTimeout:          defaultPollTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+type Option func(o *options)
+
+// WithMessageHandler sets the handler which receives every data frame read from client.
+// It overrides the handler set by SetMessageHandler.
+func WithMessageHandler(h MessageHandler) Option {
+	return func(o *options) {
+		o.handler = h
+	}
+}
+
+// WithHandleQueueSize sets how many frames can wait for the message handler.
+// Reading from client is paused when the queue is full.
+func WithHandleQueueSize(size int) Option {
+	return func(o *options) {
+		if size > 0 {
+			o.handleQueueSize = size
+		}
+	}
+}
+
+// WithDevice sets platform and device id of the connection.
+// A user can keep one connection per device, see SetDevicePolicy.
+func WithDevice(platform Platform, device string) Option {
+	return func(o *options) {
+		o.platform = platform
+		o.device = device
+	}
+}
+
+// WithCodec sets codec of the connection instead of negotiating by subprotocol.
+func WithCodec(c Codec) Option {
+	return func(o *options) {
+		o.codec = c
+	}
+}
+
+// WithHeartbeat sets how often server pings client and how long server waits for any frame
+// from client before treating it as dead. Zero value disables ping or read deadline.
+func WithHeartbeat(pingInterval, pongWait time.Duration) Option {
+	return func(o *options) {
+		o.pingInterval = pingInterval
+		o.pongWait = pongWait
+	}
+}
+
+// WithMaxIdle closes the connection if no data frame is read or written for d.
+// Control frames like ping and pong do not count. Zero value means never.
+func WithMaxIdle(d time.Duration) Option {
+	return func(o *options) {
+		o.maxIdle = d
+	}
+}
+
+// WithWriteQueue sets size of outbound queue and what to do when it is full.
+func WithWriteQueue(size int, policy OverflowPolicy) Option {
+	return func(o *options) {
+		if size > 0 {
+			o.writeQueueSize = size
+		}
+		o.overflow = policy
+	}
+}
+
+// WithWriteTimeout sets how long OverflowDropNewest waits for free space before dropping.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.writeTimeout = d
+	}
+}
+
+// WithAck enables delivery acknowledgement, see Envelope.
+// At most bufferSize unacked frames are kept for resuming within resumeTTL after disconnected,
+// frames beyond that are passed to offline fallback, see SetOfflineFallback.
+func WithAck(bufferSize int, resumeTTL time.Duration) Option {
+	return func(o *options) {
+		o.ackBufferSize = bufferSize
+		o.resumeTTL = resumeTTL
+		if o.resumeTTL <= 0 {
+			o.resumeTTL = defaultResumeTTL
+		}
+	}
+}
+
+// WithResume resumes the ack session of same key, frames after lastSeq are replayed.
+func WithResume(lastSeq uint64) Option {
+	return func(o *options) {
+		o.resume = true
+		o.lastSeq = lastSeq
+	}
+}
+
+// WithObserver adds observer to the connection, it is notified after global observers.
+func WithObserver(obs Observer) Option {
+	return func(o *options) {
+		o.observers = append(o.observers, obs)
+	}
+}
+
+// WithReadLimit sets max size in bytes of a frame read from client, zero means no limit.
+// Connection is closed with websocket.CloseMessageTooBig if the limit is exceeded.
+func WithReadLimit(limit int64) Option {
+	return func(o *options) {
+		o.readLimit = limit
+	}
+}
+
+// WithRateLimit limits frames read from client to rate per second with burst, zero rate means no limit.
+// Violations are escalated, see WithViolationThresholds.
+func WithRateLimit(rate float64, burst int) Option {
+	return func(o *options) {
+		o.rateLimit = rate
+		o.rateBurst = burst
+	}
+}
+
+// WithViolationThresholds sets how violations in a window are escalated: the first warn violations
+// are warned, violations up to close are throttled, and the connection is closed after that.
+func WithViolationThresholds(warn, close int) Option {
+	return func(o *options) {
+		o.warnViolations = warn
+		o.closeViolations = close
+		if o.closeViolations < o.warnViolations {
+			o.closeViolations = o.warnViolations
+		}
+	}
+}
+
+// WithCompressionLevel sets flate level of compressed frames, zero means default level.
+// It takes effect only if permessage-deflate is negotiated, see WithCompression.
+func WithCompressionLevel(level int) Option {
+	return func(o *options) {
+		o.compressionLevel = level
+	}
+}
+
+// WithCompressionThreshold sets the min frame size in bytes to compress, smaller frames are sent uncompressed.
+func WithCompressionThreshold(size int) Option {
+	return func(o *options) {
+		o.compressionThreshold = size
+	}
+}
+
+// WithPollTimeout sets how long a long-poll request waits for frames, connection is closed if client
+// does not poll again within it.
+func WithPollTimeout(d time.Duration) Option {
+	return func(o *options) {
+		if d > 0 {
+			o.pollTimeout = d
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/atomic"
+
+	"github.com/go-goim/core/pkg/log"
+	"github.com/go-goim/core/pkg/types"
+)
+
+var (
+	ErrPollTimeout = errors.New("http long-poll timeout")
+)
+
+// PollConn delivers frames to client by http long-poll, client posts frames by Upgrader.ServePost.
+// It is the last resort for clients which support neither websocket nor server-sent events.
+//
+// Every poll waits for frames up to poll timeout, and the response is json:
+//
+//	{"frames":[...],"reconnect":true,"target":"...","close":"reason"}
+//
+// reconnect and target are set if server asks client to reconnect to another server,
+// close is set if the connection is closed, client should poll again with a new connection then.
+type PollConn struct {
+	*httpConn
+	pollTimeout time.Duration
+	pollLock    sync.Mutex // only one poll waits at a time
+	lastPoll    atomic.Int64
+}
+
+type pollResponse struct {
+	Frames    []json.RawMessage `json:"frames"`
+	Reconnect bool              `json:"reconnect,omitempty"`
+	Target    string            `json:"target,omitempty"`
+	Close     string            `json:"close,omitempty"`
+}
+
+func (p *pollResponse) add(e *event) {
+	if e.name == reconnectEvent {
+		p.Reconnect = true
+		p.Target = string(e.data)
+		return
+	}
+
+	p.Frames = append(p.Frames, e.data)
+}
+
+// ServePoll authenticates r and waits for frames of the PollConn of same key.
+// The connection is created and added to pool at the first poll.
+func (u *Upgrader) ServePoll(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(rw, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	claims, err := u.authenticate(rw, r)
+	if err != nil {
+		return
+	}
+
+	c, ok := u.pollConn(rw, r, claims.UserID)
+	if !ok {
+		return
+	}
+
+	c.poll(r.Context(), rw)
+}
+
+// pollConn returns PollConn of same key, or creates and adds one to pool. Creating is serialized
+// by shard of user, so concurrent first polls share one connection instead of kicking each other.
+func (u *Upgrader) pollConn(rw http.ResponseWriter, r *http.Request, uid types.ID) (*PollConn, bool) {
+	s := dp.shard(uid)
+	s.connectLock.Lock()
+	defer s.connectLock.Unlock()
+
+	if c, ok := GetByDevice(uid, headerOrQuery(r, DeviceKey)).(*PollConn); ok {
+		return c, true
+	}
+
+	o := newOptions(u.connOptions(r)...)
+	c := &PollConn{
+		httpConn:    newHTTPConn(uid, o),
+		pollTimeout: o.pollTimeout,
+	}
+	c.self = c
+	c.lastPoll.Store(time.Now().UnixNano())
+	if !c.accept(rw) {
+		return nil, false
+	}
+
+	go c.daemon()
+	return c, true
+}
+
+func (c *PollConn) poll(ctx context.Context, rw http.ResponseWriter) {
+	c.pollLock.Lock()
+	defer c.pollLock.Unlock()
+	defer func() { c.lastPoll.Store(time.Now().UnixNano()) }()
+
+	resp := &pollResponse{Frames: make([]json.RawMessage, 0)}
+	timer := time.NewTimer(c.pollTimeout)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		// client goes away, frames are kept for next poll
+		return
+	case e := <-c.queue:
+		resp.add(e)
+	case <-timer.C:
+	case <-c.ctx.Done():
+	}
+
+	for _, e := range c.pending() {
+		resp.add(e)
+	}
+	if err := c.Err(); err != nil {
+		resp.Close = err.Error()
+	}
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-cache")
+	if err := json.NewEncoder(rw).Encode(resp); err != nil {
+		log.Error("long-poll write response error", "key", c.Key(), "error", err)
+		c.notify(func(o Observer) { o.OnWriteError(c, err) })
+		c.cancelWithError(err)
+		return
+	}
+	c.written.Add(uint64(len(resp.Frames)))
+}
+
+// daemon closes the connection if client does not poll again within poll timeout,
+// or once it is canceled and the waiting poll returns.
+func (c *PollConn) daemon() {
+	ticker := time.NewTicker(c.pollTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			// wait for the poll delivering frames left
+			c.pollLock.Lock()
+			c.pollLock.Unlock()
+			_ = c.Close()
+			return
+		case <-ticker.C:
+			if !c.pollLock.TryLock() {
+				continue
+			}
+			idle := time.Since(time.Unix(0, c.lastPoll.Load())) > c.pollTimeout
+			c.pollLock.Unlock()
+			if idle {
+				log.Info("long-poll conn timeout", "key", c.Key())
+				c.cancelWithError(ErrPollTimeout)
+			}
+		}
+	}
+}
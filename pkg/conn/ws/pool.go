@@ -1,94 +1,343 @@
 package ws
 
-import "sync"
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"go.uber.org/atomic"
+
+	"github.com/go-goim/core/pkg/types"
+)
 
 var dp = newNamedPool()
 
-func addToPool(c *WebsocketConn) {
-	dp.add(c)
+var (
+	ErrDeviceRejected = errors.New("device rejected by policy")
+)
+
+// addToPool adds c to pool and starts its daemon.
+func addToPool(c *WebsocketConn) error {
+	i := &idleConn{
+		WebsocketConn: c,
+		stopChan:      make(chan struct{}, 1),
+		drainChan:     make(chan string, 1),
+		p:             dp,
+	}
+	if err := c.connect(i); err != nil {
+		return err
+	}
+
+	go i.daemon()
+	return nil
 }
 
-func Get(key string) *WebsocketConn {
+// Get returns connection by key, see Conn.Key.
+func Get(key string) Conn {
 	return dp.get(key)
 }
 
-func LoadAllConn() chan *WebsocketConn {
+// GetAll returns all connections of given user.
+func GetAll(uid types.ID) []Conn {
+	return dp.getAll(uid)
+}
+
+// GetByDevice returns the connection of given user on given device.
+func GetByDevice(uid types.ID, device string) Conn {
+	return dp.getByDevice(uid, device)
+}
+
+// LoadAllConn returns a snapshot of all connections in pool.
+func LoadAllConn() chan Conn {
 	return dp.loadAllConns()
 }
 
+// Range calls f for each connection in pool until f returns false.
+// Connections are iterated by shard snapshots, pool is not locked while f runs.
+func Range(f func(c Conn) bool) {
+	dp.rangeShards(func(conns []Conn) bool {
+		for _, c := range conns {
+			if !f(c) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Count returns number of connections in pool.
+func Count() int {
+	return dp.count()
+}
+
 func CloseAndDelete(key string) {
 	dp.closeAndDelete(key)
 }
 
+// Platform is the class of client device, like mobile or desktop.
+type Platform string
+
+const (
+	PlatformUnknown Platform = ""
+	PlatformMobile  Platform = "mobile"
+	PlatformDesktop Platform = "desktop"
+	PlatformWeb     Platform = "web"
+)
+
+// DevicePolicy decides what to do when a user connects from another device of same platform.
+type DevicePolicy int
+
+const (
+	// DevicePolicyKickOld stops the old connections and keeps the new one.
+	DevicePolicyKickOld DevicePolicy = iota
+	// DevicePolicyAllowAll keeps all connections.
+	DevicePolicyAllowAll
+	// DevicePolicyRejectNew keeps the old connections and rejects the new one.
+	DevicePolicyRejectNew
+)
+
+// SetDevicePolicy sets policy for given platform. Default policy is DevicePolicyKickOld.
+// Reconnecting from the same device always replaces the old connection.
+func SetDevicePolicy(platform Platform, policy DevicePolicy) {
+	dp.setPolicy(platform, policy)
+}
+
+const keySeparator = ":"
+
+func makeKey(uid types.ID, device string) string {
+	if device == "" {
+		return uid.String()
+	}
+
+	return uid.String() + keySeparator + device
+}
+
+func splitKey(key string) (types.ID, string, bool) {
+	s, device, _ := strings.Cut(key, keySeparator)
+	uid, err := types.ParseString(s)
+	if err != nil {
+		return 0, "", false
+	}
+
+	return uid, device, true
+}
+
+// defaultShardCount must be power of two.
+const defaultShardCount = 256
+
+// namedPool is sharded by uid, so all connections of one user are in same shard.
+// Each shard has its own lock, and iteration only holds read lock of one shard at a time.
 type namedPool struct {
-	*sync.RWMutex
-	m map[string]*idleConn
+	shards []*poolShard
+	mask   uint64
+
+	policyLock sync.RWMutex
+	policies   map[Platform]DevicePolicy
+
+	draining atomic.Bool
+}
+
+type poolShard struct {
+	sync.RWMutex
+	m map[types.ID]map[string]pooledConn // uid -> device -> conn
+
+	connectLock sync.Mutex // serializes looking up and connecting conns reused across requests, see ServePoll
 }
 
 func newNamedPool() *namedPool {
+	return newShardedPool(defaultShardCount)
+}
+
+// newShardedPool creates pool with n shards, n is rounded up to power of two.
+func newShardedPool(n int) *namedPool {
+	size := 1
+	for size < n {
+		size <<= 1
+	}
+
 	p := &namedPool{
-		RWMutex: new(sync.RWMutex),
-		m:       make(map[string]*idleConn),
+		shards:   make([]*poolShard, size),
+		mask:     uint64(size - 1),
+		policies: make(map[Platform]DevicePolicy),
+	}
+	for i := range p.shards {
+		p.shards[i] = &poolShard{
+			m: make(map[types.ID]map[string]pooledConn),
+		}
 	}
 
 	return p
 }
 
-func (p *namedPool) add(c *WebsocketConn) {
-	select {
-	case <-c.ctx.Done():
-		return
-	default:
-		if c.Err() != nil {
-			return
-		}
+func (p *namedPool) shard(uid types.ID) *poolShard {
+	// fibonacci hashing spreads sequential ids
+	h := uint64(uid) * 11400714819323198485
+	return p.shards[(h>>32)&p.mask]
+}
+
+func (p *namedPool) setPolicy(platform Platform, policy DevicePolicy) {
+	p.policyLock.Lock()
+	defer p.policyLock.Unlock()
+	p.policies[platform] = policy
+}
+
+func (p *namedPool) policy(platform Platform) DevicePolicy {
+	p.policyLock.RLock()
+	defer p.policyLock.RUnlock()
+	return p.policies[platform]
+}
+
+func (p *namedPool) add(c pooledConn) error {
+	if err := c.Err(); err != nil {
+		return err
 	}
 
-	p.Lock()
-	defer p.Unlock()
-	i, loaded := p.m[c.Key()]
-	if loaded {
-		i.stop()
+	if p.draining.Load() {
+		return ErrDraining
 	}
 
-	i = &idleConn{
-		WebsocketConn: c,
-		stopChan:      make(chan struct{}),
-		p:             p,
+	policy := p.policy(c.Platform())
+	kicked, err := p.store(c, policy)
+	if err != nil {
+		return err
 	}
 
-	go i.daemon()
-	p.m[c.Key()] = i
-}
-
-func (p *namedPool) get(key string) *WebsocketConn {
-	p.RLock()
-	i, ok := p.m[key]
-	p.RUnlock()
-
-	if ok {
-		select {
-		case <-i.ctx.Done():
-			i.stop()
-		default:
-			if i.Err() != nil {
-				i.stop()
-				return nil
+	for _, i := range kicked {
+		i.kick(c.unwrap())
+	}
+
+	return nil
+}
+
+// store puts c into pool and returns connections should be kicked by c.
+func (p *namedPool) store(c pooledConn, policy DevicePolicy) ([]pooledConn, error) {
+	uid, device, platform := c.UID(), c.Device(), c.Platform()
+	s := p.shard(uid)
+	s.Lock()
+	defer s.Unlock()
+	devices, ok := s.m[uid]
+	if !ok {
+		devices = make(map[string]pooledConn)
+		s.m[uid] = devices
+	}
+
+	var kicked []pooledConn
+	if i, loaded := devices[device]; loaded {
+		kicked = append(kicked, i)
+	}
+
+	switch policy {
+	case DevicePolicyKickOld:
+		for _, i := range devices {
+			if i.Platform() == platform && i.Device() != device {
+				kicked = append(kicked, i)
+			}
+		}
+	case DevicePolicyRejectNew:
+		for _, i := range devices {
+			if i.Platform() == platform && i.Device() != device && i.Err() == nil {
+				return nil, ErrDeviceRejected
 			}
-			return i.WebsocketConn
 		}
+	case DevicePolicyAllowAll:
+	}
+
+	devices[device] = c
+	return kicked, nil
+}
+
+func (p *namedPool) get(key string) Conn {
+	uid, device, ok := splitKey(key)
+	if !ok {
+		return nil
+	}
+
+	return p.getByDevice(uid, device)
+}
+
+func (p *namedPool) lookup(uid types.ID, device string) (pooledConn, bool) {
+	s := p.shard(uid)
+	s.RLock()
+	i, ok := s.m[uid][device]
+	s.RUnlock()
+
+	return i, ok
+}
+
+func (p *namedPool) getByDevice(uid types.ID, device string) Conn {
+	i, ok := p.lookup(uid, device)
+	if ok && alive(i) {
+		return i.unwrap()
 	}
 
 	return nil
 }
 
-func (p *namedPool) loadAllConns() chan *WebsocketConn {
-	p.Lock()
-	defer p.Unlock()
+func (p *namedPool) getAll(uid types.ID) []Conn {
+	s := p.shard(uid)
+	s.RLock()
+	devices := make([]pooledConn, 0, len(s.m[uid]))
+	for _, i := range s.m[uid] {
+		devices = append(devices, i)
+	}
+	s.RUnlock()
 
-	ch := make(chan *WebsocketConn, len(p.m))
-	for _, i := range p.m {
-		ch <- i.WebsocketConn
+	conns := make([]Conn, 0, len(devices))
+	for _, i := range devices {
+		if alive(i) {
+			conns = append(conns, i.unwrap())
+		}
+	}
+
+	return conns
+}
+
+// rangeShards calls f with a snapshot of each shard, f returns false to stop.
+func (p *namedPool) rangeShards(f func(conns []Conn) bool) {
+	for _, s := range p.shards {
+		s.RLock()
+		conns := make([]Conn, 0, len(s.m))
+		for _, devices := range s.m {
+			for _, i := range devices {
+				conns = append(conns, i.unwrap())
+			}
+		}
+		s.RUnlock()
+
+		if !f(conns) {
+			return
+		}
+	}
+}
+
+func (p *namedPool) snapshot() []Conn {
+	conns := make([]Conn, 0, p.count())
+	p.rangeShards(func(s []Conn) bool {
+		conns = append(conns, s...)
+		return true
+	})
+
+	return conns
+}
+
+func (p *namedPool) count() int {
+	var cnt int
+	for _, s := range p.shards {
+		s.RLock()
+		for _, devices := range s.m {
+			cnt += len(devices)
+		}
+		s.RUnlock()
+	}
+
+	return cnt
+}
+
+func (p *namedPool) loadAllConns() chan Conn {
+	conns := p.snapshot()
+	ch := make(chan Conn, len(conns))
+	for _, c := range conns {
+		ch <- c
 	}
 
 	close(ch)
@@ -96,9 +345,12 @@ func (p *namedPool) loadAllConns() chan *WebsocketConn {
 }
 
 func (p *namedPool) closeAndDelete(key string) {
-	p.RLock()
-	i, ok := p.m[key]
-	p.RUnlock()
+	uid, device, ok := splitKey(key)
+	if !ok {
+		return
+	}
+
+	i, ok := p.lookup(uid, device)
 	if !ok {
 		return
 	}
@@ -107,8 +359,32 @@ func (p *namedPool) closeAndDelete(key string) {
 	i.stop()
 }
 
-func (p *namedPool) delete(key string) {
-	p.Lock()
-	defer p.Unlock()
-	delete(p.m, key)
+// delete removes i from pool if it has not been replaced by a new connection.
+func (p *namedPool) delete(i pooledConn) {
+	uid, device := i.UID(), i.Device()
+	s := p.shard(uid)
+	s.Lock()
+	devices, ok := s.m[uid]
+	if !ok || devices[device] != i {
+		s.Unlock()
+		return
+	}
+
+	delete(devices, device)
+	if len(devices) == 0 {
+		delete(s.m, uid)
+	}
+	s.Unlock()
+
+	rs.leaveAll(i.Key())
+}
+
+// alive reports whether i is usable, and stops it if not.
+func alive(i pooledConn) bool {
+	if i.Err() != nil {
+		i.stop()
+		return false
+	}
+
+	return true
 }
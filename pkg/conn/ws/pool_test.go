@@ -0,0 +1,178 @@
+package ws
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/go-goim/core/pkg/types"
+)
+
+func TestNamedPool_DevicePolicy(t *testing.T) {
+	var uid = types.ID(2001)
+	SetDevicePolicy(PlatformMobile, DevicePolicyKickOld)
+	SetDevicePolicy(PlatformDesktop, DevicePolicyRejectNew)
+
+	_, ch := newTestServer(t, uid, WithDevice(PlatformMobile, "phone-1"))
+	phone1 := <-ch
+	_, ch = newTestServer(t, uid, WithDevice(PlatformDesktop, "pc-1"))
+	pc1 := <-ch
+	assert.Len(t, GetAll(uid), 2)
+
+	// kick old phone
+	_, ch = newTestServer(t, uid, WithDevice(PlatformMobile, "phone-2"))
+	phone2 := <-ch
+	select {
+	case <-phone1.ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("old phone not kicked")
+	}
+	assert.Equal(t, phone2, GetByDevice(uid, "phone-2"))
+	assert.Equal(t, phone2, Get(phone2.Key()))
+
+	// reject new pc
+	_, ch = newTestServer(t, uid, WithDevice(PlatformDesktop, "pc-2"))
+	pc2 := <-ch
+	assert.Equal(t, ErrDeviceRejected, pc2.Err())
+	assert.Nil(t, GetByDevice(uid, "pc-2"))
+	assert.Equal(t, pc1, GetByDevice(uid, "pc-1"))
+
+	CloseAndDelete(pc1.Key())
+	CloseAndDelete(phone2.Key())
+	assert.Eventually(t, func() bool { return len(GetAll(uid)) == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestBroadcast(t *testing.T) {
+	var (
+		room = "group-1"
+		clis = make([]*websocket.Conn, 0)
+		keys = make([]string, 0)
+	)
+	for uid := types.ID(3001); uid < 3004; uid++ {
+		cli, ch := newTestServer(t, uid)
+		wc := <-ch
+		Join(room, wc.Key())
+		clis = append(clis, cli)
+		keys = append(keys, wc.Key())
+	}
+	Join(room, "3999") // offline member
+	assert.Len(t, Members(room), 4)
+
+	failed := Broadcast(room, []byte("hello"))
+	assert.Equal(t, []string{"3999"}, failed)
+	for _, cli := range clis {
+		_, data, err := cli.ReadMessage()
+		assert.Nil(t, err)
+		assert.Equal(t, "hello", string(data))
+	}
+
+	Leave(room, "3999")
+	CloseAndDelete(keys[0])
+	assert.Eventually(t, func() bool { return len(Members(room)) == 2 }, time.Second, 10*time.Millisecond)
+}
+
+const benchPoolSize = 1_000_000
+
+// newBenchPool returns a pool filled with n connections, daemons are not started.
+func newBenchPool(b *testing.B, n int) *namedPool {
+	b.Helper()
+	p := newNamedPool()
+	ctx, cancel := context.WithCancel(context.Background())
+	b.Cleanup(cancel)
+
+	for uid := types.ID(1); uid <= types.ID(n); uid++ {
+		storeIdle(p, &WebsocketConn{connBase: connBase{ctx: ctx, cancel: cancel, uid: uid}})
+	}
+
+	return p
+}
+
+func storeIdle(p *namedPool, c *WebsocketConn) *idleConn {
+	i := &idleConn{WebsocketConn: c, stopChan: make(chan struct{}, 1), p: p}
+	s := p.shard(c.uid)
+	s.Lock()
+	if s.m[c.uid] == nil {
+		s.m[c.uid] = make(map[string]pooledConn)
+	}
+	s.m[c.uid][c.device] = i
+	s.Unlock()
+	return i
+}
+
+func BenchmarkNamedPool_Get(b *testing.B) {
+	p := newBenchPool(b, benchPoolSize)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var uid types.ID
+		for pb.Next() {
+			uid = uid%benchPoolSize + 1
+			if p.getByDevice(uid, "") == nil {
+				b.Fatal("conn not found")
+			}
+		}
+	})
+}
+
+func BenchmarkNamedPool_GetWhileRange(b *testing.B) {
+	p := newBenchPool(b, benchPoolSize)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				p.rangeShards(func([]Conn) bool { return true })
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var uid types.ID
+		for pb.Next() {
+			uid = uid%benchPoolSize + 1
+			_ = p.getByDevice(uid, "")
+		}
+	})
+}
+
+func BenchmarkNamedPool_StoreDelete(b *testing.B) {
+	p := newBenchPool(b, benchPoolSize)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var uid types.ID
+		for pb.Next() {
+			uid = uid%benchPoolSize + 1
+			i := storeIdle(p, &WebsocketConn{connBase: connBase{ctx: ctx, cancel: cancel, uid: uid, device: "bench"}})
+			p.delete(i)
+		}
+	})
+}
+
+func BenchmarkNamedPool_Snapshot(b *testing.B) {
+	p := newBenchPool(b, benchPoolSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(p.snapshot()) != benchPoolSize {
+			b.Fatal("snapshot size mismatch")
+		}
+	}
+}
+
+func TestNamedPool_AddClosed(t *testing.T) {
+	p := newNamedPool()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := &WebsocketConn{connBase: connBase{ctx: ctx, cancel: cancel, uid: types.ID(2101)}}
+	assert.Equal(t, context.Canceled, p.add(&idleConn{WebsocketConn: c, stopChan: make(chan struct{}, 1), p: p}))
+	assert.Equal(t, 0, p.count())
+}
@@ -0,0 +1,162 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/go-goim/core/pkg/log"
+)
+
+// OverflowPolicy decides what to do when outbound queue of a connection is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDropNewest waits for write timeout then drops the frame being written.
+	OverflowDropNewest OverflowPolicy = iota
+	// OverflowDropOldest drops the oldest frame in queue to make room for the new one.
+	OverflowDropOldest
+	// OverflowBlock waits until there is room in queue or the context is done.
+	OverflowBlock
+	// OverflowDisconnect closes the connection as a slow consumer.
+	OverflowDisconnect
+)
+
+var (
+	ErrSlowConsumer = errors.New("websocket slow consumer")
+)
+
+// ConnStats is the statistics of a connection.
+type ConnStats struct {
+	QueueSize  int    // capacity of outbound queue
+	QueueDepth int    // frames waiting in outbound queue
+	Written    uint64 // frames written to client
+	Dropped    uint64 // frames dropped by overflow policy
+	Unacked    int    // frames not acknowledged by client, zero if ack is disabled
+	Violations uint64 // inbound limit violations of client
+	// PayloadBytes is bytes of frames written, WireBytes is bytes written to underlying connection.
+	// WireBytes is zero if the connection is not upgraded by Upgrader. See BytesSaved.
+	PayloadBytes uint64
+	WireBytes    uint64
+}
+
+// Stats returns statistics of the connection.
+func (w *WebsocketConn) Stats() ConnStats {
+	stats := ConnStats{
+		QueueSize:  cap(w.writeChan),
+		QueueDepth: len(w.writeChan),
+		Written:    w.written.Load(),
+		Dropped:    w.dropped.Load(),
+		Violations: w.violations.Load(),
+
+		PayloadBytes: w.payloadBytes.Load(),
+	}
+	stats.WireBytes, _ = w.wireBytes()
+	if w.ack != nil {
+		stats.Unacked = w.ack.unackedCount()
+	}
+
+	return stats
+}
+
+// WriteFrameContext puts data into outbound queue, and handles full queue by the overflow policy.
+// If ack is enabled, data is wrapped in Envelope with seq and kept until client acknowledges it,
+// so even it is dropped here, client can get it by resume.
+func (w *WebsocketConn) WriteFrameContext(ctx context.Context, mt int, data []byte) error {
+	if err := w.Err(); err != nil {
+		return err
+	}
+
+	if w.ack != nil {
+		var err error
+		if data, err = w.ack.wrap(mt, data); err != nil {
+			return err
+		}
+	}
+
+	f := &frame{mt: mt, data: data}
+	select {
+	case w.writeChan <- f:
+		return nil
+	default:
+	}
+
+	switch w.overflow {
+	case OverflowDropOldest:
+		return w.enqueueDropOldest(f)
+	case OverflowBlock:
+		return w.enqueueBlock(ctx, f)
+	case OverflowDisconnect:
+		w.dropped.Inc()
+		log.Warn("websocket slow consumer disconnected", "key", w.Key(), "queue", cap(w.writeChan))
+		w.closeWithCode(websocket.CloseTryAgainLater, "slow consumer", ErrSlowConsumer)
+		return ErrSlowConsumer
+	default:
+		return w.enqueueDropNewest(f)
+	}
+}
+
+func (w *WebsocketConn) enqueueDropNewest(f *frame) error {
+	timer := time.NewTimer(w.writeTimeout)
+	defer timer.Stop()
+	select {
+	case w.writeChan <- f:
+		return nil
+	case <-timer.C:
+		w.dropped.Inc()
+		return ErrWriteChanFull
+	}
+}
+
+func (w *WebsocketConn) enqueueDropOldest(f *frame) error {
+	for {
+		select {
+		case w.writeChan <- f:
+			return nil
+		default:
+		}
+
+		// daemon may take the oldest one at the same time, so no drop is counted then.
+		select {
+		case <-w.writeChan:
+			w.dropped.Inc()
+		default:
+		}
+	}
+}
+
+func (w *WebsocketConn) enqueueBlock(ctx context.Context, f *frame) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	select {
+	case w.writeChan <- f:
+		return nil
+	case <-ctx.Done():
+		w.dropped.Inc()
+		return ctx.Err()
+	case <-w.ctx.Done():
+		return w.Err()
+	}
+}
+
+// writeEnvelope puts control envelope into outbound queue without ack,
+// it is dropped if the queue is full.
+func (w *WebsocketConn) writeEnvelope(env *Envelope) bool {
+	mt := w.codec.FrameType()
+	data, err := EncodeEnvelope(mt, env)
+	if err != nil {
+		log.Error("websocket encode envelope error", "key", w.Key(), "kind", env.Kind, "error", err)
+		return false
+	}
+
+	select {
+	case w.writeChan <- &frame{mt: mt, data: data}:
+		return true
+	default:
+		return false
+	}
+}
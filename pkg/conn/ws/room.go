@@ -0,0 +1,163 @@
+package ws
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-goim/core/pkg/goroutine"
+)
+
+// fanoutShardSize is the max number of connections written by one goroutine in broadcast,
+// so a slow connection only delays the ones in same shard.
+var fanoutShardSize = 128
+
+var rs = newRoomSet()
+
+// Join adds connection of key to room. Connection leaves all rooms when it is removed from pool.
+func Join(room, key string) {
+	rs.join(room, key)
+}
+
+// Leave removes connection of key from room.
+func Leave(room, key string) {
+	rs.leave(room, key)
+}
+
+// Members returns keys of connections in room.
+func Members(room string) []string {
+	return rs.members(room)
+}
+
+// Broadcast writes data to all connections in room and returns keys of connections failed to write.
+func Broadcast(room string, data []byte) []string {
+	return BroadcastContext(context.Background(), room, data)
+}
+
+// BroadcastContext is same as Broadcast, ctx is passed to Conn.WriteContext.
+func BroadcastContext(ctx context.Context, room string, data []byte) []string {
+	keys := rs.members(room)
+	conns := make([]Conn, 0, len(keys))
+	failed := make([]string, 0)
+	for _, key := range keys {
+		c := Get(key)
+		if c == nil {
+			failed = append(failed, key)
+			continue
+		}
+		conns = append(conns, c)
+	}
+
+	return append(failed, fanout(ctx, conns, data)...)
+}
+
+// BroadcastAll writes data to all connections in pool and returns keys of connections failed to write.
+func BroadcastAll(ctx context.Context, data []byte) []string {
+	return fanout(ctx, dp.snapshot(), data)
+}
+
+// fanout writes data to conns in parallel shards.
+func fanout(ctx context.Context, conns []Conn, data []byte) []string {
+	var (
+		wg     sync.WaitGroup
+		lock   sync.Mutex
+		failed = make([]string, 0)
+	)
+
+	for start := 0; start < len(conns); start += fanoutShardSize {
+		end := start + fanoutShardSize
+		if end > len(conns) {
+			end = len(conns)
+		}
+
+		shard := conns[start:end]
+		f := func() {
+			defer wg.Done()
+			for _, c := range shard {
+				if err := c.WriteContext(ctx, data); err != nil {
+					lock.Lock()
+					failed = append(failed, c.Key())
+					lock.Unlock()
+				}
+			}
+		}
+
+		wg.Add(1)
+		if err := goroutine.Submit(f); err != nil {
+			f()
+		}
+	}
+
+	wg.Wait()
+	return failed
+}
+
+type roomSet struct {
+	*sync.RWMutex
+	rooms map[string]map[string]struct{} // room -> keys
+	keys  map[string]map[string]struct{} // key -> rooms
+}
+
+func newRoomSet() *roomSet {
+	return &roomSet{
+		RWMutex: new(sync.RWMutex),
+		rooms:   make(map[string]map[string]struct{}),
+		keys:    make(map[string]map[string]struct{}),
+	}
+}
+
+func (r *roomSet) join(room, key string) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.rooms[room] == nil {
+		r.rooms[room] = make(map[string]struct{})
+	}
+	r.rooms[room][key] = struct{}{}
+
+	if r.keys[key] == nil {
+		r.keys[key] = make(map[string]struct{})
+	}
+	r.keys[key][room] = struct{}{}
+}
+
+func (r *roomSet) leave(room, key string) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.remove(room, key)
+}
+
+// leaveAll removes key from all rooms it joined.
+func (r *roomSet) leaveAll(key string) {
+	r.Lock()
+	defer r.Unlock()
+
+	for room := range r.keys[key] {
+		r.remove(room, key)
+	}
+}
+
+// remove make sure lock mutex before call this func
+func (r *roomSet) remove(room, key string) {
+	delete(r.rooms[room], key)
+	if len(r.rooms[room]) == 0 {
+		delete(r.rooms, room)
+	}
+
+	delete(r.keys[key], room)
+	if len(r.keys[key]) == 0 {
+		delete(r.keys, key)
+	}
+}
+
+func (r *roomSet) members(room string) []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	keys := make([]string, 0, len(r.rooms[room]))
+	for key := range r.rooms[room] {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
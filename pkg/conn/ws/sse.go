@@ -0,0 +1,126 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-goim/core/pkg/log"
+)
+
+// SSEConn delivers frames to client by server-sent events, client posts frames by Upgrader.ServePost.
+// It is for clients behind proxies which do not support websocket.
+//
+// Data frames are sent as message events, and control events are:
+//
+//	event: reconnect, data is the server address to reconnect to, maybe empty
+//	event: close, data is the reason, it is the last event of the stream
+type SSEConn struct {
+	*httpConn
+	pingInterval time.Duration
+}
+
+// ServeSSE authenticates r, adds a SSEConn to pool and streams frames until the connection
+// is closed or client goes away. Comment lines are sent as keepalive every ping interval.
+func (u *Upgrader) ServeSSE(rw http.ResponseWriter, r *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	claims, err := u.authenticate(rw, r)
+	if err != nil {
+		return
+	}
+
+	o := newOptions(u.connOptions(r)...)
+	c := &SSEConn{
+		httpConn:     newHTTPConn(claims.UserID, o),
+		pingInterval: o.pingInterval,
+	}
+	c.self = c
+	if !c.accept(rw) {
+		return
+	}
+
+	header := rw.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	// disable buffering of nginx
+	header.Set("X-Accel-Buffering", "no")
+	rw.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	c.serve(r.Context(), rw, flusher)
+}
+
+func (c *SSEConn) serve(ctx context.Context, w io.Writer, flusher http.Flusher) {
+	defer func() { _ = c.Close() }()
+
+	var ping <-chan time.Time
+	if c.pingInterval > 0 {
+		ticker := time.NewTicker(c.pingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		var err error
+		select {
+		case <-ctx.Done():
+			// client goes away
+			c.cancelWithError(nil)
+			return
+		case <-c.ctx.Done():
+			for _, e := range c.pending() {
+				if err = c.writeEvent(w, e); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				_ = c.writeEvent(w, &event{name: closeEvent, data: []byte(c.Err().Error())})
+			}
+			flusher.Flush()
+			return
+		case e := <-c.queue:
+			err = c.writeEvent(w, e)
+		case <-ping:
+			_, err = io.WriteString(w, ": ping\n\n")
+		}
+
+		if err != nil {
+			log.Error("sse write event error", "key", c.Key(), "error", err)
+			c.notify(func(o Observer) { o.OnWriteError(c, err) })
+			c.cancelWithError(err)
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes e in event stream format, data of multiple lines is split into multiple data fields.
+func (c *SSEConn) writeEvent(w io.Writer, e *event) error {
+	var b bytes.Buffer
+	if e.name != "" {
+		b.WriteString("event: " + e.name + "\n")
+	}
+	for _, line := range bytes.Split(e.data, []byte("\n")) {
+		b.WriteString("data: ")
+		b.Write(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return err
+	}
+
+	if e.name == "" {
+		c.written.Inc()
+	}
+	return nil
+}
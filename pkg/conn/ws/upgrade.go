@@ -0,0 +1,280 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/go-goim/core/pkg/log"
+	"github.com/go-goim/core/pkg/mid"
+)
+
+const (
+	// TokenQueryKey is the query key of jwt token.
+	TokenQueryKey = "token"
+	// TokenSubprotocolPrefix is the prefix of subprotocol which carries jwt token,
+	// for clients which can not set header, like browsers.
+	TokenSubprotocolPrefix = "bearer."
+	// DeviceKey and PlatformKey are read from header first, then query.
+	DeviceKey   = "X-Goim-Device"
+	PlatformKey = "X-Goim-Platform"
+	// LastSeqKey is read from header first, then query. Connection resumes from it if set.
+	LastSeqKey = "X-Goim-Last-Seq"
+)
+
+var (
+	ErrMissingToken       = errors.New("missing token")
+	ErrOriginForbidden    = errors.New("origin forbidden")
+	ErrMissingSubprotocol = errors.New("missing codec subprotocol")
+)
+
+// Upgrader authenticates http request with jwt token, upgrades it to websocket and adds it to pool.
+// It implements http.Handler, use Handle for gin.
+// Clients which can not use websocket connect by ServeSSE or ServePoll, and post frames by ServePost.
+type Upgrader struct {
+	opts     *upgraderOptions
+	upgrader websocket.Upgrader
+}
+
+type upgraderOptions struct {
+	allowedOrigins    map[string]bool
+	allowAnyOrigin    bool
+	allowNoOrigin     bool
+	readBufferSize    int
+	writeBufferSize   int
+	enableCompression bool
+	connOpts          []Option
+}
+
+type UpgraderOption func(o *upgraderOptions)
+
+// WithAllowedOrigins sets origins allowed to connect, "*" allows any origin.
+// Only same origin is allowed if not set.
+func WithAllowedOrigins(origins ...string) UpgraderOption {
+	return func(o *upgraderOptions) {
+		for _, origin := range origins {
+			if origin == "*" {
+				o.allowAnyOrigin = true
+				continue
+			}
+			o.allowedOrigins[strings.ToLower(origin)] = true
+		}
+	}
+}
+
+// WithAllowNoOrigin allows requests without Origin header, which are sent by non-browser clients,
+// when allowed origins are set. They are always allowed if allowed origins are not set.
+func WithAllowNoOrigin(allow bool) UpgraderOption {
+	return func(o *upgraderOptions) {
+		o.allowNoOrigin = allow
+	}
+}
+
+// WithBufferSize sets io buffer size of connections, zero value means the size of http server buffer.
+func WithBufferSize(read, write int) UpgraderOption {
+	return func(o *upgraderOptions) {
+		o.readBufferSize = read
+		o.writeBufferSize = write
+	}
+}
+
+// WithCompression enables permessage-deflate negotiation.
+func WithCompression(enable bool) UpgraderOption {
+	return func(o *upgraderOptions) {
+		o.enableCompression = enable
+	}
+}
+
+// WithConnOptions sets options of upgraded connections.
+func WithConnOptions(opts ...Option) UpgraderOption {
+	return func(o *upgraderOptions) {
+		o.connOpts = append(o.connOpts, opts...)
+	}
+}
+
+func NewUpgrader(opts ...UpgraderOption) *Upgrader {
+	o := &upgraderOptions{
+		allowedOrigins: make(map[string]bool),
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	u := &Upgrader{
+		opts: o,
+	}
+	u.upgrader = websocket.Upgrader{
+		ReadBufferSize:    o.readBufferSize,
+		WriteBufferSize:   o.writeBufferSize,
+		EnableCompression: o.enableCompression,
+		// subprotocol is negotiated by negotiateSubprotocol and set in response header
+		// origin is checked before upgrade
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+
+	return u
+}
+
+// ServeHTTP implements http.Handler.
+func (u *Upgrader) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	_, _ = u.Upgrade(rw, r)
+}
+
+// Handle is gin handler of Upgrader.
+func (u *Upgrader) Handle(c *gin.Context) {
+	u.ServeHTTP(c.Writer, c.Request)
+	c.Abort()
+}
+
+// Upgrade authenticates r and upgrades it. Http error is replied if failed.
+func (u *Upgrader) Upgrade(rw http.ResponseWriter, r *http.Request) (*WebsocketConn, error) {
+	claims, err := u.authenticate(rw, r)
+	if err != nil {
+		return nil, err
+	}
+
+	protocol, err := negotiateSubprotocol(r)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+
+	var header http.Header
+	if protocol != "" {
+		header = http.Header{"Sec-Websocket-Protocol": []string{protocol}}
+	}
+
+	// Upgrade replies http error itself if failed.
+	crw := &countingResponseWriter{ResponseWriter: rw}
+	c, err := u.upgrader.Upgrade(crw, r, header)
+	if err != nil {
+		log.Info("websocket upgrade failed", "error", err, "uid", claims.UserID)
+		return nil, err
+	}
+	// handshake response is not counted
+	crw.conn.written.Store(0)
+
+	opts := u.connOptions(r)
+	if seq, err := strconv.ParseUint(headerOrQuery(r, LastSeqKey), 10, 64); err == nil {
+		opts = append(opts, WithResume(seq))
+	}
+
+	// request context is canceled after ServeHTTP returns, so not use it here.
+	wc := WrapWs(context.Background(), c, claims.UserID, opts...)
+	return wc, wc.Err()
+}
+
+// authenticate checks origin and token of r, http error is replied if failed.
+// It is shared by all transports.
+func (u *Upgrader) authenticate(rw http.ResponseWriter, r *http.Request) (*mid.JwtClaims, error) {
+	if Draining() {
+		http.Error(rw, ErrDraining.Error(), http.StatusServiceUnavailable)
+		return nil, ErrDraining
+	}
+
+	if !u.checkOrigin(r) {
+		http.Error(rw, ErrOriginForbidden.Error(), http.StatusForbidden)
+		return nil, ErrOriginForbidden
+	}
+
+	token := extractToken(r)
+	if token == "" {
+		http.Error(rw, ErrMissingToken.Error(), http.StatusUnauthorized)
+		return nil, ErrMissingToken
+	}
+
+	claims, err := mid.ParseJwtToken(token)
+	if err != nil {
+		log.Info("conn authenticate with invalid token", "error", err, "remote", r.RemoteAddr)
+		http.Error(rw, "invalid token", http.StatusUnauthorized)
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+// connOptions returns options of connection accepted from r.
+func (u *Upgrader) connOptions(r *http.Request) []Option {
+	return append([]Option{
+		WithDevice(Platform(headerOrQuery(r, PlatformKey)), headerOrQuery(r, DeviceKey)),
+	}, u.opts.connOpts...)
+}
+
+// checkOrigin allows request without origin header, which is not sent by browsers, only if
+// allowed origins are not set or WithAllowNoOrigin is set.
+func (u *Upgrader) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return len(u.opts.allowedOrigins) == 0 || u.opts.allowNoOrigin
+	}
+
+	if u.opts.allowAnyOrigin {
+		return true
+	}
+
+	if u.opts.allowedOrigins[strings.ToLower(origin)] {
+		return true
+	}
+
+	ou, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(ou.Host, r.Host)
+}
+
+// extractToken reads token from Authorization header, query or Sec-WebSocket-Protocol header in order.
+func extractToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	if token := r.URL.Query().Get(TokenQueryKey); token != "" {
+		return token
+	}
+
+	for _, p := range websocket.Subprotocols(r) {
+		if strings.HasPrefix(p, TokenSubprotocolPrefix) {
+			return strings.TrimPrefix(p, TokenSubprotocolPrefix)
+		}
+	}
+
+	return ""
+}
+
+// negotiateSubprotocol returns the registered codec subprotocol preferred by server among ones requested.
+// Browsers fail the handshake if none of requested subprotocols is echoed, so a codec subprotocol is
+// required when token is sent as subprotocol.
+func negotiateSubprotocol(r *http.Request) (string, error) {
+	requested := websocket.Subprotocols(r)
+	for _, s := range Subprotocols() {
+		for _, p := range requested {
+			if p == s {
+				return s, nil
+			}
+		}
+	}
+
+	for _, p := range requested {
+		if strings.HasPrefix(p, TokenSubprotocolPrefix) {
+			return "", ErrMissingSubprotocol
+		}
+	}
+
+	return "", nil
+}
+
+func headerOrQuery(r *http.Request, key string) string {
+	if v := r.Header.Get(key); v != "" {
+		return v
+	}
+
+	return r.URL.Query().Get(key)
+}
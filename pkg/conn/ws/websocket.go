@@ -8,37 +8,84 @@ import (
 	"github.com/go-goim/core/pkg/types"
 
 	"github.com/gorilla/websocket"
+	"go.uber.org/atomic"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/go-goim/core/pkg/log"
 )
 
 type WebsocketConn struct {
+	connBase
 	conn *websocket.Conn
 
-	ctx    context.Context
-	cancel context.CancelFunc
+	codec        Codec
+	writeChan    chan *frame
+	overflow     OverflowPolicy
+	writeTimeout time.Duration
+	written      atomic.Uint64
+	payloadBytes atomic.Uint64 // bytes of frames written, before compression
+	dropped      atomic.Uint64
+	readChan     chan *frame
+	ack          *ackSession
+	handler      MessageHandler
 
-	uid          types.ID
-	writeChan    chan []byte
-	onWriteError func()
-	err          error
+	// inbound limits, only accessed by readDaemon except violations
+	limiter         *tokenBucket
+	warnViolations  int
+	closeViolations int
+	violationCount  int
+	lastViolation   time.Time
+	violations      atomic.Uint64
+
+	compressionThreshold int
+	wire                 *countingConn // nil if not upgraded by Upgrader
+
+	// heartbeat
+	pingInterval time.Duration
+	pongWait     time.Duration
+	maxIdle      time.Duration
+	lastActive   atomic.Int64 // unix nano of last data frame
 }
 
 var (
 	ErrWriteChanFull = errors.New("writeToClient chan full")
 )
 
-func WrapWs(ctx context.Context, c *websocket.Conn, uid types.ID) *WebsocketConn {
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	ctx2, cancel := context.WithCancel(ctx)
+// WrapWs wraps c and adds it to pool.
+// Err of returned conn is ErrDeviceRejected if pool rejected it, see SetDevicePolicy.
+func WrapWs(ctx context.Context, c *websocket.Conn, uid types.ID, opts ...Option) *WebsocketConn {
+	o := newOptions(opts...)
 	wc := &WebsocketConn{
-		ctx:       ctx2,
+		connBase:  newConnBase(ctx, uid, o),
 		conn:      c,
-		uid:       uid,
-		writeChan: make(chan []byte, 1),
-		cancel:    cancel,
+		codec:     o.codec,
+		writeChan: make(chan *frame, o.writeQueueSize),
+		overflow:  o.overflow,
+		handler:   o.handler,
+
+		writeTimeout: o.writeTimeout,
+
+		pingInterval: o.pingInterval,
+		pongWait:     o.pongWait,
+		maxIdle:      o.maxIdle,
+
+		warnViolations:  o.warnViolations,
+		closeViolations: o.closeViolations,
+	}
+	wc.touch()
+	if wc.codec == nil {
+		wc.codec = GetCodec(c.Subprotocol())
+	}
+	wc.setupCompression(o)
+	if o.readLimit > 0 {
+		wc.conn.SetReadLimit(o.readLimit)
+	}
+	if o.rateLimit > 0 {
+		wc.limiter = newTokenBucket(o.rateLimit, o.rateBurst)
+	}
+	if wc.handler != nil {
+		wc.readChan = make(chan *frame, o.handleQueueSize)
+		go wc.dispatchDaemon()
 	}
 
 	wc.conn.SetCloseHandler(func(code int, text string) error {
@@ -49,6 +96,7 @@ func WrapWs(ctx context.Context, c *websocket.Conn, uid types.ID) *WebsocketConn
 	})
 
 	wc.conn.SetPingHandler(func(message string) error {
+		wc.extendReadDeadline()
 		err := c.WriteControl(websocket.PongMessage, []byte(message), time.Now().Add(time.Second))
 		if err == nil || err == websocket.ErrCloseSent {
 			return nil
@@ -57,23 +105,36 @@ func WrapWs(ctx context.Context, c *websocket.Conn, uid types.ID) *WebsocketConn
 		return err
 	})
 
-	go wc.readDaemon()
-	// add to pool
-	addToPool(wc)
+	wc.conn.SetPongHandler(func(string) error {
+		wc.extendReadDeadline()
+		return nil
+	})
 
-	return wc
-}
+	if o.ackBufferSize > 0 {
+		var (
+			replay []*unackedFrame
+			gap    bool
+		)
+		wc.ack, replay, gap = sessions.attach(wc, o)
+		if err := wc.replay(replay, gap); err != nil {
+			log.Error("websocket replay error", "key", wc.Key(), "error", err)
+			wc.cancelWithError(err)
+		}
+	}
 
-func (w *WebsocketConn) AddCloseAction(f func() error) {
-	cf := w.conn.CloseHandler()
-	w.conn.SetCloseHandler(func(code int, text string) error {
-		err := cf(code, text)
-		if err == nil {
-			return f()
+	wc.extendReadDeadline()
+	go wc.readDaemon()
+	if err := addToPool(wc); err != nil {
+		log.Info("websocket conn rejected", "key", wc.Key(), "error", err)
+		code := websocket.ClosePolicyViolation
+		if err == ErrDraining {
+			code = websocket.CloseServiceRestart
 		}
+		wc.closeWithCode(code, err.Error(), err)
+		_ = wc.Close()
+	}
 
-		return err
-	})
+	return wc
 }
 
 func (w *WebsocketConn) AddPingAction(f func() error) {
@@ -88,68 +149,127 @@ func (w *WebsocketConn) AddPingAction(f func() error) {
 	})
 }
 
-func (w *WebsocketConn) cancelWithError(e error) {
-	w.err = e
-	w.cancel()
-}
-
-func (w *WebsocketConn) Key() string {
-	return w.uid.String()
-}
-
-func (w *WebsocketConn) Err() error {
-	if w.err != nil {
-		return w.err
-	}
-
-	if w.ctx.Err() != nil {
-		return w.ctx.Err()
-	}
-
-	return nil
-}
-
 func (w *WebsocketConn) Close() error {
 	// cancel context
 	w.cancel()
 	// close connection
-	return w.conn.Close()
+	err := w.conn.Close()
+	if w.ack != nil {
+		sessions.detach(w)
+	}
+	w.runCloseActions(w)
+	return err
 }
 
-// readDaemon is keep read msg from connection, and handle registered ping, pong, close events
+// readDaemon is keep read msg from connection, and handle registered ping, pong, close events.
+// Data frames are passed to dispatchDaemon if message handler is set.
 func (w *WebsocketConn) readDaemon() {
 	for {
 		mt, message, err := w.conn.ReadMessage()
 		if err != nil {
-			log.Error("websocket read message error", "error", err, "uid", w.uid)
+			if err == websocket.ErrReadLimit {
+				// close frame with websocket.CloseMessageTooBig is already sent by websocket.Conn
+				log.Warn("websocket read limit exceeded", "key", w.Key())
+				w.violate(ViolationSize)
+				w.cancelWithError(err)
+				return
+			}
+
+			// error caused by closing from server side is not a read error.
+			if w.ctx.Err() == nil &&
+				!websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Error("websocket read message error", "error", err, "uid", w.uid)
+				w.notify(func(o Observer) { o.OnReadError(w, err) })
+			}
 			w.cancelWithError(err)
 			return
 		}
-		log.Info("websocket read message", "uid", w.uid, "mt", mt, "message", string(message))
+
+		w.extendReadDeadline()
+		w.touch()
+		if !w.limitRate() {
+			// connection is closed by the limit
+			return
+		}
+
+		if w.ack != nil {
+			env, err := DecodeEnvelope(mt, message)
+			if err != nil {
+				log.Info("websocket read invalid envelope", "uid", w.uid, "error", err)
+				continue
+			}
+
+			if env.Kind == KindAck {
+				w.ack.ack(env.Seq)
+				continue
+			}
+
+			if env.Kind != KindData {
+				continue
+			}
+			message = env.Payload
+		}
+
+		if w.handler == nil {
+			log.Info("websocket read message", "uid", w.uid, "mt", mt, "message", string(message))
+			continue
+		}
+
+		select {
+		case w.readChan <- &frame{mt: mt, data: message}:
+		case <-w.ctx.Done():
+			return
+		}
 	}
 }
 
+// Write writes data in frame type of the connection codec.
+// Data should be encoded by the codec, use WriteMessage if not.
 func (w *WebsocketConn) Write(data []byte) error {
-	select {
-	case w.writeChan <- data:
-		return nil
-	default:
-	}
+	return w.WriteFrame(w.codec.FrameType(), data)
+}
 
-	timer := time.NewTimer(time.Millisecond * 10)
-	select {
-	case w.writeChan <- data:
-		return nil
-	case <-timer.C:
-		return ErrWriteChanFull
+// WriteMessage encodes m by the connection codec and writes it.
+func (w *WebsocketConn) WriteMessage(m proto.Message) error {
+	data, err := w.codec.Marshal(m)
+	if err != nil {
+		return err
 	}
+
+	return w.WriteFrame(w.codec.FrameType(), data)
+}
+
+// WriteFrame writes data in given frame type, mt is websocket.TextMessage or websocket.BinaryMessage.
+func (w *WebsocketConn) WriteFrame(mt int, data []byte) error {
+	return w.WriteFrameContext(context.Background(), mt, data)
+}
+
+// WriteContext is same as Write, ctx is used by OverflowBlock policy to limit the waiting time.
+func (w *WebsocketConn) WriteContext(ctx context.Context, data []byte) error {
+	return w.WriteFrameContext(ctx, w.codec.FrameType(), data)
+}
+
+// Decode decodes data read from client by the connection codec.
+func (w *WebsocketConn) Decode(data []byte, m proto.Message) error {
+	return w.codec.Unmarshal(data, m)
+}
+
+// Codec returns codec negotiated at upgrade time.
+func (w *WebsocketConn) Codec() Codec {
+	return w.codec
 }
 
-func (w *WebsocketConn) writeToClient(data []byte) {
+func (w *WebsocketConn) writeToClient(f *frame) {
 	_ = w.conn.SetWriteDeadline(time.Now().Add(time.Second))
-	err := w.conn.WriteMessage(websocket.TextMessage, data)
+	w.enableCompression(len(f.data))
+	err := w.conn.WriteMessage(f.mt, f.data)
 	if err != nil {
-		w.onWriteError()
+		log.Error("websocket write message error", "error", err, "uid", w.uid)
+		w.notify(func(o Observer) { o.OnWriteError(w, err) })
+		w.cancelWithError(err)
 		return
 	}
+	w.written.Inc()
+	w.payloadBytes.Add(uint64(len(f.data)))
+	w.touch()
 }
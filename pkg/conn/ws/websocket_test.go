@@ -0,0 +1,479 @@
+package ws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	messagev1 "github.com/go-goim/api/message/v1"
+	"github.com/go-goim/core/pkg/mid"
+	"github.com/go-goim/core/pkg/types"
+)
+
+// newTestServer starts a server which wraps every upgraded connection with given options,
+// and returns a connected client.
+func newTestServer(t *testing.T, uid types.ID, opts ...Option) (*websocket.Conn, chan *WebsocketConn) {
+	return newTestServerWithDialer(t, websocket.DefaultDialer, uid, opts...)
+}
+
+func newTestServerWithDialer(t *testing.T, dialer *websocket.Dialer, uid types.ID, opts ...Option) (
+	*websocket.Conn, chan *WebsocketConn) {
+	var (
+		upgrader = websocket.Upgrader{Subprotocols: Subprotocols()}
+		ch       = make(chan *WebsocketConn, 1)
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(rw, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		ch <- WrapWs(context.Background(), c, uid, opts...)
+	}))
+	t.Cleanup(srv.Close)
+
+	cli, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = cli.Close() })
+
+	return cli, ch
+}
+
+func TestWebsocketConn_MessageHandler(t *testing.T) {
+	var received = make(chan string, 1)
+	h := MessageHandlerFunc(func(ctx context.Context, c Conn, mt int, data []byte) error {
+		if string(data) == "bye" {
+			return NewCloseError(websocket.ClosePolicyViolation, "bye")
+		}
+
+		received <- string(data)
+		return nil
+	})
+
+	cli, ch := newTestServer(t, 1001, WithMessageHandler(h))
+	wc := <-ch
+
+	assert.Nil(t, cli.WriteMessage(websocket.TextMessage, []byte("hello")))
+	select {
+	case got := <-received:
+		assert.Equal(t, "hello", got)
+	case <-time.After(time.Second):
+		t.Fatal("message not dispatched")
+	}
+
+	assert.Nil(t, cli.WriteMessage(websocket.TextMessage, []byte("bye")))
+	_, _, err := cli.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+
+	select {
+	case <-wc.ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("connection not closed")
+	}
+	_, ok := wc.Err().(*CloseError)
+	assert.True(t, ok)
+}
+
+func TestWebsocketConn_Codec(t *testing.T) {
+	dialer := &websocket.Dialer{Subprotocols: []string{SubprotocolProtobuf}}
+	cli, ch := newTestServerWithDialer(t, dialer, 1002)
+	wc := <-ch
+	assert.Equal(t, ProtobufCodec, wc.Codec())
+
+	msg := &messagev1.Message{MsgId: 1, From: 1, To: 2, Content: "hello"}
+	assert.Nil(t, wc.WriteMessage(msg))
+
+	mt, data, err := cli.ReadMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, websocket.BinaryMessage, mt)
+
+	got := new(messagev1.Message)
+	assert.Nil(t, wc.Decode(data, got))
+	assert.Equal(t, msg.GetContent(), got.GetContent())
+
+	cli, ch = newTestServer(t, 1003)
+	wc = <-ch
+	assert.Equal(t, JSONCodec, wc.Codec())
+	assert.Nil(t, wc.WriteMessage(msg))
+
+	mt, _, err = cli.ReadMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, websocket.TextMessage, mt)
+}
+
+func TestWebsocketConn_Heartbeat(t *testing.T) {
+	// client never reads, so it never answers ping
+	_, ch := newTestServer(t, 1004, WithHeartbeat(20*time.Millisecond, 50*time.Millisecond))
+	wc := <-ch
+
+	closed := make(chan struct{})
+	wc.AddCloseAction(func() error {
+		close(closed)
+		return nil
+	})
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("dead peer not evicted")
+	}
+	assert.Nil(t, Get(wc.Key()))
+
+	// client keeps answering ping but sends nothing
+	cli, ch := newTestServer(t, 1005, WithHeartbeat(20*time.Millisecond, 50*time.Millisecond),
+		WithMaxIdle(100*time.Millisecond))
+	wc = <-ch
+	go func() {
+		for {
+			if _, _, err := cli.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-wc.ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("idle conn not evicted")
+	}
+	assert.Equal(t, ErrIdleTimeout, wc.Err())
+}
+
+func TestWebsocketConn_Overflow(t *testing.T) {
+	newConn := func(policy OverflowPolicy) *WebsocketConn {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		return &WebsocketConn{
+			connBase:     connBase{ctx: ctx, cancel: cancel},
+			codec:        JSONCodec,
+			writeChan:    make(chan *frame, 2),
+			overflow:     policy,
+			writeTimeout: time.Millisecond,
+		}
+	}
+
+	wc := newConn(OverflowDropNewest)
+	assert.Nil(t, wc.Write([]byte("1")))
+	assert.Nil(t, wc.Write([]byte("2")))
+	assert.Equal(t, ErrWriteChanFull, wc.Write([]byte("3")))
+	assert.Equal(t, ConnStats{QueueSize: 2, QueueDepth: 2, Dropped: 1}, wc.Stats())
+	assert.Equal(t, "1", string((<-wc.writeChan).data))
+
+	wc = newConn(OverflowDropOldest)
+	assert.Nil(t, wc.Write([]byte("1")))
+	assert.Nil(t, wc.Write([]byte("2")))
+	assert.Nil(t, wc.Write([]byte("3")))
+	assert.Equal(t, uint64(1), wc.Stats().Dropped)
+	assert.Equal(t, "2", string((<-wc.writeChan).data))
+
+	wc = newConn(OverflowBlock)
+	assert.Nil(t, wc.Write([]byte("1")))
+	assert.Nil(t, wc.Write([]byte("2")))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, wc.WriteContext(ctx, []byte("3")))
+	go func() { <-wc.writeChan }()
+	assert.Nil(t, wc.WriteContext(context.Background(), []byte("3")))
+}
+
+func TestUpgrader(t *testing.T) {
+	u := NewUpgrader(WithAllowedOrigins("https://goim.example"))
+	srv := httptest.NewServer(u)
+	defer srv.Close()
+	addr := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	h := http.Header{"Origin": []string{"https://goim.example"}}
+	_, resp, err := websocket.DefaultDialer.Dial(addr, h)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	token, err := mid.NewJwtToken(4001)
+	assert.Nil(t, err)
+
+	h = http.Header{"Origin": []string{"https://evil.example"}}
+	_, resp, err = websocket.DefaultDialer.Dial(addr+"?token="+token, h)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// no origin is rejected when allowed origins are set
+	_, resp, err = websocket.DefaultDialer.Dial(addr+"?token="+token, nil)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// token subprotocol requires codec subprotocol to echo
+	h = http.Header{"Origin": []string{"https://goim.example"}}
+	dialer := &websocket.Dialer{Subprotocols: []string{TokenSubprotocolPrefix + token}}
+	_, resp, err = dialer.Dial(addr, h)
+	assert.NotNil(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	dialer = &websocket.Dialer{Subprotocols: []string{SubprotocolProtobuf, TokenSubprotocolPrefix + token}}
+	h = http.Header{"Origin": []string{"https://goim.example"}, PlatformKey: []string{"web"}}
+	cli, resp, err := dialer.Dial(addr+"?"+DeviceKey+"=browser-1", h)
+	assert.Nil(t, err)
+	defer cli.Close()
+	assert.Equal(t, SubprotocolProtobuf, resp.Header.Get("Sec-WebSocket-Protocol"))
+
+	assert.Eventually(t, func() bool { return GetByDevice(4001, "browser-1") != nil }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, PlatformWeb, GetByDevice(4001, "browser-1").Platform())
+	assert.Equal(t, ProtobufCodec, GetByDevice(4001, "browser-1").(*WebsocketConn).codec)
+}
+
+func TestUpgrader_AllowNoOrigin(t *testing.T) {
+	srv := httptest.NewServer(NewUpgrader(WithAllowedOrigins("https://goim.example"), WithAllowNoOrigin(true)))
+	defer srv.Close()
+
+	token, err := mid.NewJwtToken(4002)
+	assert.Nil(t, err)
+	cli, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?token="+token, nil)
+	assert.Nil(t, err)
+	cli.Close()
+}
+
+func TestWebsocketConn_AckAndResume(t *testing.T) {
+	var (
+		uid      = types.ID(1006)
+		fallback = make(chan string, 10)
+	)
+	SetOfflineFallback(func(ctx context.Context, id types.ID, payload []byte) error {
+		fallback <- string(payload)
+		return nil
+	})
+	defer SetOfflineFallback(nil)
+	// session outlives the test, drop it so repeated runs start from seq 1
+	defer func() {
+		sessions.Lock()
+		delete(sessions.m, makeKey(uid, ""))
+		sessions.Unlock()
+	}()
+
+	readEnvelope := func(cli *websocket.Conn) *Envelope {
+		mt, data, err := cli.ReadMessage()
+		assert.Nil(t, err)
+		env, err := DecodeEnvelope(mt, data)
+		assert.Nil(t, err)
+		return env
+	}
+
+	cli, ch := newTestServer(t, uid, WithAck(2, time.Minute))
+	wc := <-ch
+	for _, data := range []string{"1", "2", "3"} {
+		assert.Nil(t, wc.Write([]byte(data)))
+	}
+	for seq := uint64(1); seq <= 3; seq++ {
+		env := readEnvelope(cli)
+		assert.Equal(t, KindData, env.Kind)
+		assert.Equal(t, seq, env.Seq)
+	}
+	// seq 1 is out of buffer
+	assert.Equal(t, "1", <-fallback)
+
+	ack, _ := EncodeEnvelope(websocket.TextMessage, &Envelope{Kind: KindAck, Seq: 2})
+	assert.Nil(t, cli.WriteMessage(websocket.TextMessage, ack))
+	assert.Eventually(t, func() bool { return wc.Stats().Unacked == 1 }, time.Second, 10*time.Millisecond)
+	_ = cli.Close()
+	<-wc.ctx.Done()
+
+	// client missed seq 2, server lost it already
+	cli, _ = newTestServer(t, uid, WithAck(2, time.Minute), WithResume(1))
+	env := readEnvelope(cli)
+	assert.Equal(t, KindResync, env.Kind)
+	assert.Equal(t, uint64(3), env.Seq)
+	env = readEnvelope(cli)
+	assert.Equal(t, KindData, env.Kind)
+	assert.Equal(t, uint64(3), env.Seq)
+	assert.Equal(t, "3", string(env.Payload))
+}
+
+type testObserver struct {
+	BaseObserver
+	events chan string
+}
+
+func (o *testObserver) OnConnect(c Conn) {
+	o.events <- "connect:" + c.Device()
+}
+
+func (o *testObserver) OnClose(c Conn, reason error) {
+	o.events <- "close:" + c.Device()
+}
+
+func (o *testObserver) OnKicked(c Conn, by Conn) {
+	o.events <- "kicked:" + c.Device() + ":" + by.Device()
+}
+
+func TestWebsocketConn_Observer(t *testing.T) {
+	var (
+		uid = types.ID(1007)
+		obs = &testObserver{events: make(chan string, 10)}
+	)
+	SetDevicePolicy(PlatformDesktop, DevicePolicyKickOld)
+
+	cli, ch := newTestServer(t, uid, WithObserver(obs), WithDevice(PlatformDesktop, "pc-1"))
+	wc := <-ch
+	assert.Equal(t, "connect:pc-1", <-obs.events)
+
+	_, _ = newTestServer(t, uid, WithObserver(obs), WithDevice(PlatformDesktop, "pc-2"))
+	assert.Equal(t, "kicked:pc-1:pc-2", <-obs.events)
+	assert.ElementsMatch(t, []string{"connect:pc-2", "close:pc-1"}, []string{<-obs.events, <-obs.events})
+	assert.Equal(t, ErrKicked, wc.Err())
+
+	_, _, err := cli.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, CloseKicked))
+}
+
+// closingObserver adds close action and closes c in OnConnect.
+type closingObserver struct {
+	testObserver
+}
+
+func (o *closingObserver) OnConnect(c Conn) {
+	c.AddCloseAction(func() error {
+		o.events <- "action:" + c.Device()
+		return nil
+	})
+	_ = c.Close()
+	o.testObserver.OnConnect(c)
+}
+
+func TestWebsocketConn_ObserverClosesOnConnect(t *testing.T) {
+	obs := &closingObserver{testObserver{events: make(chan string, 10)}}
+
+	_, ch := newTestServer(t, types.ID(1013), WithObserver(obs), WithDevice(PlatformDesktop, "pc-1"))
+	wc := <-ch
+	select {
+	case <-wc.Done():
+	case <-time.After(time.Second):
+		t.Fatal("conn deadlocked by observer")
+	}
+
+	// close action runs at once, OnClose is deferred to after OnConnect
+	assert.Equal(t, []string{"action:pc-1", "connect:pc-1", "close:pc-1"}, []string{<-obs.events, <-obs.events, <-obs.events})
+}
+
+type violationObserver struct {
+	BaseObserver
+	violations chan Violation
+}
+
+func (o *violationObserver) OnViolation(c Conn, v Violation) {
+	o.violations <- v
+}
+
+func TestWebsocketConn_Limit(t *testing.T) {
+	t.Run("rate", func(t *testing.T) {
+		obs := &violationObserver{violations: make(chan Violation, 10)}
+		cli, ch := newTestServer(t, 1008, WithObserver(obs), WithMessageHandler(nil),
+			WithRateLimit(20, 1), WithViolationThresholds(1, 2))
+		wc := <-ch
+		for i := 0; i < 4; i++ {
+			assert.Nil(t, cli.WriteMessage(websocket.TextMessage, []byte("hi")))
+		}
+
+		mt, data, err := cli.ReadMessage()
+		assert.Nil(t, err)
+		env, err := DecodeEnvelope(mt, data)
+		assert.Nil(t, err)
+		assert.Equal(t, KindWarn, env.Kind)
+
+		_, _, err = cli.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation))
+		assert.Equal(t, ActionWarn, (<-obs.violations).Action)
+		assert.Equal(t, ActionThrottle, (<-obs.violations).Action)
+		assert.Equal(t, Violation{Kind: ViolationRate, Action: ActionClose, Count: 3}, <-obs.violations)
+		assert.Equal(t, ErrRateLimited, wc.Err())
+		assert.Equal(t, uint64(3), wc.Stats().Violations)
+	})
+
+	t.Run("size", func(t *testing.T) {
+		obs := &violationObserver{violations: make(chan Violation, 10)}
+		cli, ch := newTestServer(t, 1009, WithObserver(obs), WithReadLimit(8))
+		wc := <-ch
+		assert.Nil(t, cli.WriteMessage(websocket.TextMessage, []byte("more than 8 bytes")))
+
+		_, _, err := cli.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig))
+		assert.Equal(t, Violation{Kind: ViolationSize, Action: ActionClose, Count: 1}, <-obs.violations)
+		assert.Equal(t, websocket.ErrReadLimit, wc.Err())
+	})
+}
+
+func TestUpgrader_Compression(t *testing.T) {
+	u := NewUpgrader(WithCompression(true), WithConnOptions(WithCompressionThreshold(64)))
+	srv := httptest.NewServer(u)
+	defer srv.Close()
+
+	token, err := mid.NewJwtToken(4002)
+	assert.Nil(t, err)
+	dialer := &websocket.Dialer{EnableCompression: true}
+	cli, _, err := dialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?token="+token, nil)
+	assert.Nil(t, err)
+	defer cli.Close()
+
+	var wc *WebsocketConn
+	assert.Eventually(t, func() bool { wc, _ = Get("4002").(*WebsocketConn); return wc != nil }, time.Second, 10*time.Millisecond)
+
+	// below threshold, not compressed
+	assert.Nil(t, wc.Write([]byte(`"hi"`)))
+	_, data, err := cli.ReadMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, `"hi"`, string(data))
+	assert.True(t, wc.Stats().BytesSaved() < 0)
+
+	payload := `"` + strings.Repeat("hello goim ", 100) + `"`
+	assert.Nil(t, wc.Write([]byte(payload)))
+	_, data, err = cli.ReadMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, payload, string(data))
+
+	stats := wc.Stats()
+	assert.Equal(t, uint64(len(payload)+4), stats.PayloadBytes)
+	assert.True(t, stats.BytesSaved() > int64(len(payload)/2), stats)
+}
+
+func TestDrain(t *testing.T) {
+	defer dp.draining.Store(false)
+
+	var clis []*websocket.Conn
+	for _, uid := range []types.ID{1010, 1011} {
+		cli, ch := newTestServer(t, uid)
+		wc := <-ch
+		assert.Nil(t, wc.Write([]byte(`"pending"`)))
+		clis = append(clis, cli)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Nil(t, Drain(ctx, WithDrainTarget("10.0.0.2:8080"), WithDrainBatch(1, 10*time.Millisecond)))
+	assert.True(t, Draining())
+
+	for _, cli := range clis {
+		_, data, err := cli.ReadMessage()
+		assert.Nil(t, err)
+		assert.Equal(t, `"pending"`, string(data))
+
+		mt, data, err := cli.ReadMessage()
+		assert.Nil(t, err)
+		env, err := DecodeEnvelope(mt, data)
+		assert.Nil(t, err)
+		assert.Equal(t, KindReconnect, env.Kind)
+		assert.Equal(t, `"10.0.0.2:8080"`, string(env.Payload))
+
+		_, _, err = cli.ReadMessage()
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseServiceRestart))
+		assert.Equal(t, "10.0.0.2:8080", err.(*websocket.CloseError).Text)
+	}
+
+	_, ch := newTestServer(t, 1012)
+	assert.Equal(t, ErrDraining, (<-ch).Err())
+}
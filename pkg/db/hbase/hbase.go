@@ -306,7 +306,7 @@ func GetClient() Client {
 
 func InitClient(opts ...Option) error {
 	defaultHBaseClient = NewClient(opts...)
-	graceful.Register(func(ctx context.Context) error {
+	graceful.RegisterPhase(graceful.PhaseClose, func(ctx context.Context) error {
 		return Close()
 	})
 
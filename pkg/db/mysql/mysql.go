@@ -30,7 +30,7 @@ func InitDB(opts ...Option) error {
 		return err
 	}
 
-	graceful.Register(func(ctx context.Context) error {
+	graceful.RegisterPhase(graceful.PhaseClose, func(ctx context.Context) error {
 		err := Close()
 		if err != nil {
 			log.Error("mysql close error", "err", err)
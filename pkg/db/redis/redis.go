@@ -24,7 +24,7 @@ func InitRedis(opts ...Option) error {
 		return err
 	}
 
-	graceful.Register(func(ctx context.Context) error {
+	graceful.RegisterPhase(graceful.PhaseClose, func(ctx context.Context) error {
 		return Close()
 	})
 
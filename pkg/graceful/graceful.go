@@ -9,13 +9,28 @@ import (
 	"github.com/go-goim/core/pkg/log"
 )
 
+// Phase is phase of graceful shutdown, phases run in order and functions of same phase run concurrently.
+type Phase int
+
+const (
+	// PhaseDrain moves long-lived connections to other servers, it runs before servers stop
+	// so that their handlers return.
+	PhaseDrain Phase = iota
+	// PhaseStop finishes in-flight work, e.g. goroutine pools and wait groups.
+	PhaseStop
+	// PhaseClose closes stores and clients after all work of PhaseStop finished.
+	PhaseClose
+
+	phaseCount
+)
+
 var (
 	// DefaultTimeout is the default timeout for graceful shutdown.
 	DefaultTimeout = 30 * time.Second
 
 	// need a function set to store all the graceful shutdown functions
 	// so that we can call them all at once when the server is shutdown.
-	gracefulShutdownFuncs []gracefulShutdownFunc
+	gracefulShutdownFuncs [phaseCount][]gracefulShutdownFunc
 )
 
 // gracefulShutdownFunc is a function that is called when the server is shutdown.
@@ -35,18 +50,37 @@ func Shutdown(ctx context.Context) error {
 		defer cancel()
 	}
 
+	errs := make(errors.ErrorSet, 0)
+	for p := PhaseDrain; p < phaseCount; p++ {
+		if err := ShutdownPhase(ctx, p); err != nil {
+			errs = append(errs, err)
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return errs.Err()
+}
+
+// ShutdownPhase calls all functions registered to phase p concurrently and blocks until they return
+// or ctx is done.
+func ShutdownPhase(ctx context.Context, p Phase) error {
 	var (
-		done = make(chan struct{})
+		done = make(chan struct{}, 1)
 		errs = make(errors.ErrorSet, 0)
+		lock sync.Mutex
 		wg   sync.WaitGroup
 	)
 
-	for _, f := range gracefulShutdownFuncs {
+	for _, f := range gracefulShutdownFuncs[p] {
 		wg.Add(1)
 		go func(f gracefulShutdownFunc) {
 			defer wg.Done()
 			if err := f(ctx); err != nil {
+				lock.Lock()
 				errs = append(errs, err)
+				lock.Unlock()
 			}
 		}(f)
 	}
@@ -66,5 +100,10 @@ func Shutdown(ctx context.Context) error {
 
 // Register registers a function to be called when the server is shutdown.
 func Register(f gracefulShutdownFunc) {
-	gracefulShutdownFuncs = append(gracefulShutdownFuncs, f)
+	RegisterPhase(PhaseStop, f)
+}
+
+// RegisterPhase registers a function to be called in phase p of shutdown.
+func RegisterPhase(p Phase, f gracefulShutdownFunc) {
+	gracefulShutdownFuncs[p] = append(gracefulShutdownFuncs[p], f)
 }
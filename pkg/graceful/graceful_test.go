@@ -0,0 +1,53 @@
+package graceful
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown(t *testing.T) {
+	defer func() { gracefulShutdownFuncs = [phaseCount][]gracefulShutdownFunc{} }()
+
+	var (
+		lock  sync.Mutex
+		order []string
+	)
+	record := func(name string, err error) gracefulShutdownFunc {
+		return func(ctx context.Context) error {
+			time.Sleep(10 * time.Millisecond)
+			lock.Lock()
+			order = append(order, name)
+			lock.Unlock()
+			return err
+		}
+	}
+
+	RegisterPhase(PhaseClose, record("store", nil))
+	Register(record("pool", errors.New("pool")))
+	Register(record("pool", nil))
+	RegisterPhase(PhaseDrain, record("conn", nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// error of stop phase does not stop closing stores
+	assert.NotNil(t, Shutdown(ctx))
+	assert.Equal(t, []string{"conn", "pool", "pool", "store"}, order)
+}
+
+func TestShutdownPhase_Timeout(t *testing.T) {
+	defer func() { gracefulShutdownFuncs = [phaseCount][]gracefulShutdownFunc{} }()
+
+	Register(func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, ShutdownPhase(ctx, PhaseStop))
+}
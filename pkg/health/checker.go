@@ -0,0 +1,95 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	redisv8 "github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+
+	"github.com/go-goim/core/pkg/db/hbase"
+	"github.com/go-goim/core/pkg/registry"
+)
+
+// Checker checks whether a dependency is ready to serve.
+type Checker interface {
+	Name() string
+	Check(ctx context.Context) error
+}
+
+type checkerFunc struct {
+	name string
+	f    func(ctx context.Context) error
+}
+
+func (c *checkerFunc) Name() string {
+	return c.name
+}
+
+func (c *checkerFunc) Check(ctx context.Context) error {
+	return c.f(ctx)
+}
+
+// CheckerFunc returns Checker of name which calls f.
+func CheckerFunc(name string, f func(ctx context.Context) error) Checker {
+	return &checkerFunc{name: name, f: f}
+}
+
+// Redis pings redis.
+func Redis(rdb *redisv8.Client) Checker {
+	return CheckerFunc("redis", func(ctx context.Context) error {
+		return rdb.Ping(ctx).Err()
+	})
+}
+
+// MySQL pings database.
+func MySQL(db *gorm.DB) Checker {
+	return CheckerFunc("mysql", func(ctx context.Context) error {
+		sqlDB, err := db.DB()
+		if err != nil {
+			return err
+		}
+
+		return sqlDB.PingContext(ctx)
+	})
+}
+
+// HBase gets a row of table, "hbase:meta" is a good choice which always exists.
+func HBase(cli hbase.Client, table string) Checker {
+	return CheckerFunc("hbase", func(ctx context.Context) error {
+		return cli.Context(ctx).Table(table).Key("health").Get().Err()
+	})
+}
+
+// NameServer dials addrs of mq name servers, it is ready if any of them is reachable.
+// Producer of rocketmq has no way to ping, and it can not send without name server.
+func NameServer(addrs []string) Checker {
+	return CheckerFunc("mq", func(ctx context.Context) error {
+		if len(addrs) == 0 {
+			return fmt.Errorf("no name server")
+		}
+
+		var (
+			d   net.Dialer
+			err error
+		)
+		for _, addr := range addrs {
+			var conn net.Conn
+			conn, err = d.DialContext(ctx, "tcp", addr)
+			if err == nil {
+				return conn.Close()
+			}
+		}
+
+		return fmt.Errorf("no name server reachable: %w", err)
+	})
+}
+
+// Registry lists instances of service from registry.
+func Registry(d registry.RegisterDiscover, service string) Checker {
+	return CheckerFunc("registry", func(ctx context.Context) error {
+		_, err := d.GetService(ctx, service)
+		return err
+	})
+}
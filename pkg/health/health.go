@@ -0,0 +1,318 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/go-goim/core/pkg/log"
+)
+
+var errNotChecked = errors.New("not checked yet")
+
+var defaultHealth *Health
+
+// Init creates Health used by package functions.
+func Init(opts ...Option) *Health {
+	defaultHealth = New(opts...)
+	return defaultHealth
+}
+
+// AddChecker is wrapper for default Health.AddChecker.
+func AddChecker(checkers ...Checker) {
+	if defaultHealth != nil {
+		defaultHealth.AddChecker(checkers...)
+	}
+}
+
+// SetNotReady is wrapper for default Health.SetNotReady.
+func SetNotReady(component, reason string) {
+	if defaultHealth != nil {
+		defaultHealth.SetNotReady(component, reason)
+	}
+}
+
+// SetReady is wrapper for default Health.SetReady.
+func SetReady(component string) {
+	if defaultHealth != nil {
+		defaultHealth.SetReady(component)
+	}
+}
+
+// Drain is wrapper for default Health.Drain.
+func Drain() {
+	if defaultHealth != nil {
+		defaultHealth.Drain()
+	}
+}
+
+// Report is readiness of Health, returned by readiness endpoint as json.
+type Report struct {
+	Ready    bool `json:"ready"`
+	Draining bool `json:"draining,omitempty"`
+	// Checks is result of each checker, "ok" or error message.
+	Checks map[string]string `json:"checks,omitempty"`
+	// NotReady is components marked not ready with reason.
+	NotReady map[string]string `json:"not_ready,omitempty"`
+}
+
+// Health reports liveness and readiness by http endpoints and grpc health checking protocol.
+// It is ready after Start when all checkers passed, no component is marked not ready and not draining.
+// Checkers run in background every interval, so probes never hit dependencies directly.
+type Health struct {
+	opts *options
+	grpc *health.Server
+
+	lock     sync.RWMutex
+	checkers []Checker
+	results  map[string]error
+	notReady map[string]string
+	draining bool
+	started  bool
+	ready    bool
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New creates Health, it is not ready until Start.
+func New(opts ...Option) *Health {
+	h := &Health{
+		opts:     newOptions(opts...),
+		grpc:     health.NewServer(),
+		results:  make(map[string]error),
+		notReady: make(map[string]string),
+		done:     make(chan struct{}),
+	}
+	h.setServingStatus(false)
+
+	return h
+}
+
+// AddChecker adds checkers, they are not ready until next check.
+func (h *Health) AddChecker(checkers ...Checker) {
+	h.lock.Lock()
+	h.checkers = append(h.checkers, checkers...)
+	for _, c := range checkers {
+		h.results[c.Name()] = errNotChecked
+	}
+	h.lock.Unlock()
+
+	h.update()
+}
+
+// SetNotReady marks component not ready with reason, e.g. a gateway is moving connections away.
+func (h *Health) SetNotReady(component, reason string) {
+	h.lock.Lock()
+	h.notReady[component] = reason
+	h.lock.Unlock()
+
+	h.update()
+}
+
+// SetReady clears not ready mark of component.
+func (h *Health) SetReady(component string) {
+	h.lock.Lock()
+	delete(h.notReady, component)
+	h.lock.Unlock()
+
+	h.update()
+}
+
+// Drain marks the application not ready for good, call it before shutdown so that
+// load balancers stop sending new requests while in flight ones finish.
+func (h *Health) Drain() {
+	h.lock.Lock()
+	h.draining = true
+	h.lock.Unlock()
+
+	h.update()
+}
+
+// Ready reports whether the application is ready to serve.
+func (h *Health) Ready() bool {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return h.ready
+}
+
+// Report returns readiness with details.
+func (h *Health) Report() *Report {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+
+	r := &Report{
+		Ready:    h.ready,
+		Draining: h.draining,
+		Checks:   make(map[string]string, len(h.results)),
+		NotReady: make(map[string]string, len(h.notReady)),
+	}
+	for name, err := range h.results {
+		if err != nil {
+			r.Checks[name] = err.Error()
+		} else {
+			r.Checks[name] = "ok"
+		}
+	}
+	for component, reason := range h.notReady {
+		r.NotReady[component] = reason
+	}
+
+	return r
+}
+
+// GrpcServer returns grpc health service, register it to grpc server of which default
+// health service is disabled.
+func (h *Health) GrpcServer() grpc_health_v1.HealthServer {
+	return h.grpc
+}
+
+// ServeLiveness always responds ok as long as the process is able to serve http.
+func (h *Health) ServeLiveness(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte("ok"))
+}
+
+// ServeReadiness responds Report, with status 503 if not ready.
+func (h *Health) ServeReadiness(w http.ResponseWriter, _ *http.Request) {
+	r := h.Report()
+	code := http.StatusOK
+	if !r.Ready {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(r)
+}
+
+// Start runs checkers at once and then every interval. It implements transport.Server of kratos.
+func (h *Health) Start(_ context.Context) error {
+	h.lock.Lock()
+	if h.started {
+		h.lock.Unlock()
+		return nil
+	}
+	var ctx context.Context
+	ctx, h.cancel = context.WithCancel(context.Background())
+	h.started = true
+	h.lock.Unlock()
+
+	h.check(ctx)
+	go h.loop(ctx)
+
+	return nil
+}
+
+// Stop drains and stops running checkers. It implements transport.Server of kratos.
+func (h *Health) Stop(_ context.Context) error {
+	h.Drain()
+
+	h.lock.RLock()
+	started, cancel := h.started, h.cancel
+	h.lock.RUnlock()
+	if !started {
+		return nil
+	}
+
+	cancel()
+	<-h.done
+	// no status changes any more
+	h.grpc.Shutdown()
+	return nil
+}
+
+func (h *Health) loop(ctx context.Context) {
+	defer close(h.done)
+	ticker := time.NewTicker(h.opts.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.check(ctx)
+		}
+	}
+}
+
+// check runs all checkers concurrently.
+func (h *Health) check(ctx context.Context) {
+	h.lock.RLock()
+	checkers := make([]Checker, len(h.checkers))
+	copy(checkers, h.checkers)
+	h.lock.RUnlock()
+
+	var (
+		wg      sync.WaitGroup
+		lock    sync.Mutex
+		results = make(map[string]error, len(checkers))
+	)
+	for _, c := range checkers {
+		wg.Add(1)
+		go func(c Checker) {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, h.opts.timeout)
+			defer cancel()
+
+			err := c.Check(cctx)
+			lock.Lock()
+			results[c.Name()] = err
+			lock.Unlock()
+		}(c)
+	}
+	wg.Wait()
+
+	h.lock.Lock()
+	for name, err := range results {
+		if err != nil && h.results[name] == nil {
+			log.Warn("health check failed", "checker", name, "err", err)
+		}
+		h.results[name] = err
+	}
+	h.lock.Unlock()
+
+	h.update()
+}
+
+// update evaluates readiness and reports it to grpc health service when changed.
+func (h *Health) update() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	ready := h.started && !h.draining && len(h.notReady) == 0
+	for _, err := range h.results {
+		if err != nil {
+			ready = false
+			break
+		}
+	}
+
+	if ready == h.ready {
+		return
+	}
+
+	// set under lock so that concurrent updates are reported in order
+	h.ready = ready
+	h.setServingStatus(ready)
+	log.Info("health readiness changed", "ready", ready)
+}
+
+func (h *Health) setServingStatus(ready bool) {
+	status := grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	if ready {
+		status = grpc_health_v1.HealthCheckResponse_SERVING
+	}
+
+	h.grpc.SetServingStatus("", status)
+	for _, s := range h.opts.services {
+		h.grpc.SetServingStatus(s, status)
+	}
+}
@@ -0,0 +1,84 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/atomic"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func servingStatus(t *testing.T, h *Health, service string) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	resp, err := h.GrpcServer().Check(context.Background(), &grpc_health_v1.HealthCheckRequest{Service: service})
+	assert.NoError(t, err)
+	return resp.GetStatus()
+}
+
+func readiness(h *Health) (int, *Report) {
+	w := httptest.NewRecorder()
+	h.ServeReadiness(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	r := new(Report)
+	_ = json.NewDecoder(w.Body).Decode(r)
+	return w.Code, r
+}
+
+func TestHealth(t *testing.T) {
+	var redisErr atomic.Error
+	h := New(WithInterval(10*time.Millisecond), WithServices("push"))
+	h.AddChecker(
+		CheckerFunc("redis", func(ctx context.Context) error { return redisErr.Load() }),
+		CheckerFunc("mysql", func(ctx context.Context) error { return nil }),
+	)
+
+	// not ready before start
+	code, _ := readiness(h)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, servingStatus(t, h, ""))
+
+	assert.NoError(t, h.Start(context.Background()))
+	code, r := readiness(h)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]string{"redis": "ok", "mysql": "ok"}, r.Checks)
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, servingStatus(t, h, ""))
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, servingStatus(t, h, "push"))
+
+	// failed checker
+	redisErr.Store(errors.New("connection refused"))
+	assert.Eventually(t, func() bool { return !h.Ready() }, time.Second, 5*time.Millisecond)
+	code, r = readiness(h)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "connection refused", r.Checks["redis"])
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, servingStatus(t, h, "push"))
+
+	redisErr.Store(nil)
+	assert.Eventually(t, h.Ready, time.Second, 5*time.Millisecond)
+
+	// component flips itself
+	h.SetNotReady("gateway", "moving connections")
+	assert.False(t, h.Ready())
+	_, r = readiness(h)
+	assert.Equal(t, map[string]string{"gateway": "moving connections"}, r.NotReady)
+	h.SetReady("gateway")
+	assert.True(t, h.Ready())
+
+	// draining is never ready again
+	h.Drain()
+	assert.False(t, h.Ready())
+	time.Sleep(30 * time.Millisecond)
+	assert.False(t, h.Ready())
+	assert.Equal(t, grpc_health_v1.HealthCheckResponse_NOT_SERVING, servingStatus(t, h, ""))
+
+	assert.NoError(t, h.Stop(context.Background()))
+
+	// liveness is always ok
+	w := httptest.NewRecorder()
+	h.ServeLiveness(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
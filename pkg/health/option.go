@@ -0,0 +1,54 @@
+package health
+
+import "time"
+
+type options struct {
+	interval time.Duration
+	timeout  time.Duration
+	services []string
+}
+
+const (
+	defaultInterval = 5 * time.Second
+	defaultTimeout  = time.Second
+)
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		interval: defaultInterval,
+		timeout:  defaultTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+type Option func(o *options)
+
+// WithInterval sets interval of running checkers.
+func WithInterval(interval time.Duration) Option {
+	return func(o *options) {
+		if interval > 0 {
+			o.interval = interval
+		}
+	}
+}
+
+// WithTimeout sets timeout of each checker.
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		if timeout > 0 {
+			o.timeout = timeout
+		}
+	}
+}
+
+// WithServices sets names of grpc services reported by grpc health service besides the overall "".
+func WithServices(services ...string) Option {
+	return func(o *options) {
+		o.services = append(o.services, services...)
+	}
+}
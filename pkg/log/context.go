@@ -0,0 +1,48 @@
+package log
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/trace"
+
+	configv1 "github.com/go-goim/api/config/v1"
+)
+
+// DebugContext is Debug with trace_id and span_id of span in ctx.
+func DebugContext(ctx context.Context, msg string, keyvals ...interface{}) {
+	global.Log(configv1.Level_DEBUG, msg, withSpan(ctx, keyvals)...)
+}
+
+// InfoContext is Info with trace_id and span_id of span in ctx.
+func InfoContext(ctx context.Context, msg string, keyvals ...interface{}) {
+	global.Log(configv1.Level_INFO, msg, withSpan(ctx, keyvals)...)
+}
+
+// WarnContext is Warn with trace_id and span_id of span in ctx.
+func WarnContext(ctx context.Context, msg string, keyvals ...interface{}) {
+	global.Log(configv1.Level_WARNING, msg, withSpan(ctx, keyvals)...)
+}
+
+// ErrorContext is Error with trace_id and span_id of span in ctx.
+func ErrorContext(ctx context.Context, msg string, keyvals ...interface{}) {
+	global.Log(configv1.Level_ERROR, msg, withSpan(ctx, keyvals)...)
+}
+
+func withSpan(ctx context.Context, keyvals []interface{}) []interface{} {
+	if ctx == nil {
+		return keyvals
+	}
+
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return keyvals
+	}
+
+	kvs := make([]interface{}, 0, len(keyvals)+5)
+	kvs = append(kvs, keyvals...)
+	if len(kvs)%2 != 0 {
+		kvs = append(kvs, "UNPAIRED_KEY")
+	}
+
+	return append(kvs, "trace_id", sc.TraceID().String(), "span_id", sc.SpanID().String())
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/go-goim/core/pkg/log"
+)
+
+// Namespace is prefix of all metrics of goim.
+const Namespace = "goim"
+
+var registry = prometheus.NewRegistry()
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Register registers collectors to registry exposed by Handler. Subsystems call it when initialized,
+// registering a collector twice is ignored so that it is safe to call in constructors.
+func Register(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		err := registry.Register(c)
+		if err == nil {
+			continue
+		}
+
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			continue
+		}
+
+		log.Error("register metrics collector failed", "err", err)
+	}
+}
+
+// Unregister removes collectors from registry.
+func Unregister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		registry.Unregister(c)
+	}
+}
+
+// Gatherer returns registry of all collectors.
+func Gatherer() prometheus.Gatherer {
+	return registry
+}
+
+// Handler serves metrics in prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
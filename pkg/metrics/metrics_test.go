@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type testTransport struct {
+	transport.Transporter
+	operation string
+}
+
+func (t *testTransport) Kind() transport.Kind {
+	return transport.KindGRPC
+}
+
+func (t *testTransport) Operation() string {
+	return t.operation
+}
+
+func TestServer(t *testing.T) {
+	m := Server()
+	ok := m(func(ctx context.Context, req interface{}) (interface{}, error) { return "ok", nil })
+	fail := m(func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, status.Error(codes.NotFound, "not found")
+	})
+
+	okRequests := serverRequests.WithLabelValues("grpc", "/api.v1.User/Get", "OK")
+	notFoundRequests := serverRequests.WithLabelValues("grpc", "/api.v1.User/Get", "NotFound")
+	// collectors are global, so only changes made by this test are checked
+	okBefore, notFoundBefore := testutil.ToFloat64(okRequests), testutil.ToFloat64(notFoundRequests)
+
+	ctx := transport.NewServerContext(context.Background(), &testTransport{operation: "/api.v1.User/Get"})
+	reply, err := ok(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", reply)
+	_, _ = ok(ctx, nil)
+	_, err = fail(ctx, nil)
+	assert.Error(t, err)
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(okRequests)-okBefore)
+	assert.Equal(t, float64(1), testutil.ToFloat64(notFoundRequests)-notFoundBefore)
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(w.Body)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, string(body), fmt.Sprintf(
+		`goim_server_requests_total{code="NotFound",kind="grpc",operation="/api.v1.User/Get"} %v`, notFoundBefore+1))
+	assert.Contains(t, string(body), "goim_server_request_duration_seconds_bucket")
+	assert.Contains(t, string(body), "go_goroutines")
+}
+
+func TestRegister(t *testing.T) {
+	c := prometheus.NewCounter(prometheus.CounterOpts{Namespace: Namespace, Name: "test_total", Help: "test"})
+	// registering twice is ignored
+	Register(c)
+	Register(c)
+	c.Inc()
+
+	n, err := testutil.GatherAndCount(Gatherer(), "goim_test_total")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	Unregister(c)
+	n, err = testutil.GatherAndCount(Gatherer(), "goim_test_total")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	serverRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: Namespace,
+		Subsystem: "server",
+		Name:      "requests_total",
+		Help:      "Total number of requests handled by servers.",
+	}, []string{"kind", "operation", "code"})
+
+	serverSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: Namespace,
+		Subsystem: "server",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of requests handled by servers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"kind", "operation", "code"})
+)
+
+// Server returns kratos server middleware which counts requests and observes latency by
+// transport kind, operation and code. Code is grpc code name for both http and grpc,
+// as kratos errors are converted to grpc status.
+func Server() middleware.Middleware {
+	Register(serverRequests, serverSeconds)
+
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (interface{}, error) {
+			var kind, operation string
+			if info, ok := transport.FromServerContext(ctx); ok {
+				kind = info.Kind().String()
+				operation = info.Operation()
+			}
+
+			start := time.Now()
+			reply, err := handler(ctx, req)
+			code := status.Code(err).String()
+			serverRequests.WithLabelValues(kind, operation, code).Inc()
+			serverSeconds.WithLabelValues(kind, operation, code).Observe(time.Since(start).Seconds())
+
+			return reply, err
+		}
+	}
+}
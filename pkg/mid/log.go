@@ -35,5 +35,9 @@ func Logger(c *gin.Context) {
 		kvs = append(kvs, "uid", c.GetString("uid"))
 	}
 
-	log.Info(path, kvs...)
+	if rid := GetRequestID(c); rid != "" {
+		kvs = append(kvs, RequestIDKey, rid)
+	}
+
+	log.InfoContext(GetContext(c), path, kvs...)
 }
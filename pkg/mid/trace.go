@@ -1,6 +1,73 @@
 package mid
 
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/go-goim/core/pkg/tracing"
+	"github.com/go-goim/core/pkg/util"
+)
+
 const (
 	// RequestIDKey is the key used to store the request ID in the context.
 	RequestIDKey = "request_id"
+	// RequestIDHeader is header of request id in requests and responses.
+	RequestIDHeader = "X-Request-ID"
 )
+
+// Trace starts server span of request with trace context from request headers, and keeps the span
+// context by SetContext so that handlers continue the trace with GetContext.
+// Request id is X-Request-ID of request or trace id if not set, it is stored by RequestIDKey
+// and returned in response header.
+func Trace(c *gin.Context) {
+	route := c.FullPath()
+	if route == "" {
+		route = c.Request.URL.Path
+	}
+
+	ctx := tracing.Propagator().Extract(c.Request.Context(), propagation.HeaderCarrier(c.Request.Header))
+	ctx, span := tracing.Tracer().Start(ctx, c.Request.Method+" "+route,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(c.Request.Method),
+			semconv.HTTPRouteKey.String(route),
+			semconv.HTTPTargetKey.String(c.Request.URL.Path),
+		))
+	defer span.End()
+
+	rid := c.GetHeader(RequestIDHeader)
+	if rid == "" {
+		if sc := span.SpanContext(); sc.HasTraceID() {
+			rid = sc.TraceID().String()
+		} else {
+			rid = util.UUID()
+		}
+	}
+	span.SetAttributes(attribute.String(RequestIDKey, rid))
+	c.Set(RequestIDKey, rid)
+	c.Header(RequestIDHeader, rid)
+
+	c.Request = c.Request.WithContext(ctx)
+	SetContext(c, ctx)
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+	if err := c.Errors.Last(); err != nil {
+		span.RecordError(err)
+	}
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
+
+// GetRequestID returns request id set by Trace.
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
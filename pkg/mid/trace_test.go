@@ -0,0 +1,50 @@
+package mid
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/go-goim/core/pkg/tracing"
+)
+
+func TestTrace(t *testing.T) {
+	exporter := tracing.NewMemoryExporter()
+	assert.Nil(t, tracing.Init(tracing.WithExporter(exporter), tracing.WithSyncExport()))
+
+	var handlerSpan trace.SpanContext
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Trace)
+	r.GET("/users/:id", func(c *gin.Context) {
+		handlerSpan = trace.SpanContextFromContext(GetContext(c))
+		c.Status(http.StatusInternalServerError)
+	})
+
+	// trace is continued from traceparent and trace id is used as request id
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	assert.Equal(t, traceID, w.Header().Get(RequestIDHeader))
+	assert.Equal(t, traceID, handlerSpan.TraceID().String())
+	spans := exporter.GetSpans()
+	if assert.Len(t, spans, 1) {
+		assert.Equal(t, "GET /users/:id", spans[0].Name)
+		assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent.SpanID().String())
+		assert.Equal(t, handlerSpan.SpanID(), spans[0].SpanContext.SpanID())
+	}
+
+	// request id of request is kept
+	req = httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.Header.Set(RequestIDHeader, "rid")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	assert.Equal(t, "rid", w.Header().Get(RequestIDHeader))
+}
@@ -0,0 +1,93 @@
+package mq
+
+import (
+	"context"
+
+	"github.com/apache/rocketmq-client-go/v2"
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/go-goim/core/pkg/metrics"
+)
+
+var (
+	producedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "mq",
+		Name:      "produced_messages_total",
+		Help:      "Total number of messages sent by producers.",
+	}, []string{"topic", "result"})
+
+	consumedMessages = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "mq",
+		Name:      "consumed_messages_total",
+		Help:      "Total number of messages consumed by consumers.",
+	}, []string{"topic", "group", "result"})
+)
+
+// instrumentedProducer counts messages sent by result, messages of Request are not counted.
+type instrumentedProducer struct {
+	rocketmq.Producer
+}
+
+func withProducerMetrics(p rocketmq.Producer) Producer {
+	metrics.Register(producedMessages)
+	return &instrumentedProducer{Producer: p}
+}
+
+func countProduced(msgs []*primitive.Message, ok bool) {
+	result := "ok"
+	if !ok {
+		result = "error"
+	}
+
+	for _, m := range msgs {
+		producedMessages.WithLabelValues(m.Topic, result).Inc()
+	}
+}
+
+func (p *instrumentedProducer) SendSync(ctx context.Context, msgs ...*primitive.Message) (*primitive.SendResult, error) {
+	r, err := p.Producer.SendSync(ctx, msgs...)
+	countProduced(msgs, err == nil && r.Status == primitive.SendOK)
+	return r, err
+}
+
+func (p *instrumentedProducer) SendAsync(ctx context.Context,
+	f func(ctx context.Context, result *primitive.SendResult, err error), msgs ...*primitive.Message) error {
+	err := p.Producer.SendAsync(ctx, func(ctx context.Context, result *primitive.SendResult, err error) {
+		countProduced(msgs, err == nil)
+		f(ctx, result, err)
+	}, msgs...)
+	if err != nil {
+		countProduced(msgs, false)
+	}
+
+	return err
+}
+
+func (p *instrumentedProducer) SendOneWay(ctx context.Context, msgs ...*primitive.Message) error {
+	err := p.Producer.SendOneWay(ctx, msgs...)
+	countProduced(msgs, err == nil)
+	return err
+}
+
+// consumeWithMetrics wraps Consume of s to count messages by result.
+func consumeWithMetrics(s Subscriber) SubscribeCallback {
+	metrics.Register(consumedMessages)
+
+	return func(ctx context.Context, msgs ...*primitive.MessageExt) (consumer.ConsumeResult, error) {
+		r, err := s.Consume(ctx, msgs...)
+
+		result := "success"
+		if err != nil || r != consumer.ConsumeSuccess {
+			result = "retry"
+		}
+		for _, m := range msgs {
+			consumedMessages.WithLabelValues(m.Topic, s.Group(), result).Inc()
+		}
+
+		return r, err
+	}
+}
@@ -41,7 +41,7 @@ func NewProducer(cfg *ProducerConfig) (Producer, error) {
 		return nil, err
 	}
 
-	return p, nil
+	return withProducerTracing(withProducerMetrics(p)), nil
 }
 
 type ConsumerConfig struct {
@@ -86,7 +86,7 @@ func NewConsumer(cfg *ConsumerConfig) (Consumer, error) {
 	}
 
 	for i := 0; i < cfg.Concurrence; i++ {
-		if err = c.Subscribe(cfg.Subscriber.Topic(), consumer.MessageSelector{}, cfg.Subscriber.Consume); err != nil {
+		if err = c.Subscribe(cfg.Subscriber.Topic(), consumer.MessageSelector{}, consumeWithMetrics(withSubscriberTracing(cfg.Subscriber))); err != nil {
 			return nil, err
 		}
 	}
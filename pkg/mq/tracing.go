@@ -0,0 +1,140 @@
+package mq
+
+import (
+	"context"
+
+	"github.com/apache/rocketmq-client-go/v2/consumer"
+	"github.com/apache/rocketmq-client-go/v2/primitive"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/go-goim/core/pkg/tracing"
+)
+
+// messageCarrier adapts properties of message to propagation.TextMapCarrier.
+type messageCarrier struct {
+	msg *primitive.Message
+}
+
+func (c messageCarrier) Get(key string) string {
+	return c.msg.GetProperty(key)
+}
+
+func (c messageCarrier) Set(key, value string) {
+	c.msg.WithProperty(key, value)
+}
+
+func (c messageCarrier) Keys() []string {
+	props := c.msg.GetProperties()
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+
+	return keys
+}
+
+// tracedProducer starts a producer span of each send and injects its context into properties of messages.
+type tracedProducer struct {
+	Producer
+}
+
+func withProducerTracing(p Producer) Producer {
+	return &tracedProducer{Producer: p}
+}
+
+func startProducerSpan(ctx context.Context, msgs []*primitive.Message) (context.Context, trace.Span) {
+	var topic string
+	if len(msgs) > 0 {
+		topic = msgs[0].Topic
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, topic+" send",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("rocketmq"),
+			semconv.MessagingDestinationKindTopic,
+			semconv.MessagingDestinationKey.String(topic),
+			attribute.Int("messaging.batch_size", len(msgs)),
+		))
+
+	for _, m := range msgs {
+		tracing.Propagator().Inject(ctx, messageCarrier{msg: m})
+	}
+
+	return ctx, span
+}
+
+func (p *tracedProducer) SendSync(ctx context.Context, msgs ...*primitive.Message) (*primitive.SendResult, error) {
+	ctx, span := startProducerSpan(ctx, msgs)
+	r, err := p.Producer.SendSync(ctx, msgs...)
+	if err == nil {
+		span.SetAttributes(semconv.MessagingMessageIDKey.String(r.MsgID))
+	}
+	tracing.End(span, err)
+	return r, err
+}
+
+func (p *tracedProducer) SendAsync(ctx context.Context,
+	f func(ctx context.Context, result *primitive.SendResult, err error), msgs ...*primitive.Message) error {
+	ctx, span := startProducerSpan(ctx, msgs)
+	err := p.Producer.SendAsync(ctx, func(ctx context.Context, result *primitive.SendResult, err error) {
+		tracing.End(span, err)
+		f(ctx, result, err)
+	}, msgs...)
+	if err != nil {
+		tracing.End(span, err)
+	}
+
+	return err
+}
+
+func (p *tracedProducer) SendOneWay(ctx context.Context, msgs ...*primitive.Message) error {
+	ctx, span := startProducerSpan(ctx, msgs)
+	err := p.Producer.SendOneWay(ctx, msgs...)
+	tracing.End(span, err)
+	return err
+}
+
+// tracedSubscriber continues trace of first message in a consumer span, other messages are linked to the span.
+type tracedSubscriber struct {
+	Subscriber
+}
+
+func withSubscriberTracing(s Subscriber) Subscriber {
+	return &tracedSubscriber{Subscriber: s}
+}
+
+func (s *tracedSubscriber) Consume(ctx context.Context, msgs ...*primitive.MessageExt) (consumer.ConsumeResult, error) {
+	var links []trace.Link
+	for i, m := range msgs {
+		mctx := tracing.Propagator().Extract(ctx, messageCarrier{msg: &m.Message})
+		if i == 0 {
+			ctx = mctx
+			continue
+		}
+		if sc := trace.SpanContextFromContext(mctx); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+
+	ctx, span := tracing.Tracer().Start(ctx, s.Topic()+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("rocketmq"),
+			semconv.MessagingDestinationKindTopic,
+			semconv.MessagingDestinationKey.String(s.Topic()),
+			semconv.MessagingOperationProcess,
+			semconv.MessagingConsumerIDKey.String(s.Group()),
+			attribute.Int("messaging.batch_size", len(msgs)),
+		))
+
+	r, err := s.Subscriber.Consume(ctx, msgs...)
+	if err == nil && r != consumer.ConsumeSuccess {
+		span.SetAttributes(attribute.Bool("messaging.retry", true))
+	}
+	tracing.End(span, err)
+	return r, err
+}
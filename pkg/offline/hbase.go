@@ -0,0 +1,205 @@
+package offline
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/tsuna/gohbase/filter"
+	"github.com/tsuna/gohbase/hrpc"
+
+	"github.com/go-goim/core/pkg/db/hbase"
+	"github.com/go-goim/core/pkg/types"
+)
+
+const (
+	hbaseFamily       = "q"
+	hbaseQualifierMsg = "p"
+	hbaseQualifierSeq = "seq"
+)
+
+// hbaseStore keeps a message per row keyed by "uid:seq" and last seq of a user in row "uid".
+// Expiry relies on cell ttl. Seqs are contiguous, so Enqueue trims the rows pushed out of max members
+// by itself without scanning the queue; a row put later than the trim of a concurrent Enqueue, or left
+// by a larger max members, is kept until expired.
+type hbaseStore struct {
+	client hbase.Client
+	opts   *options
+}
+
+var _ Store = &hbaseStore{}
+
+// NewHBaseStore creates Store backed by hbase, table is set by WithTable and must have family "q".
+func NewHBaseStore(cli hbase.Client, opts ...Option) Store {
+	return &hbaseStore{
+		client: cli,
+		opts:   newOptions(opts...),
+	}
+}
+
+func seqRowKey(uid types.ID) string {
+	return fmt.Sprintf("%d", uid)
+}
+
+// msgRowKey pads seq so that rows of a user are sorted by seq.
+func msgRowKey(uid types.ID, seq int64) string {
+	return fmt.Sprintf("%d:%020d", uid, seq)
+}
+
+// msgRowEnd is the stop row of all messages of uid, ';' is next to ':'.
+func msgRowEnd(uid types.ID) string {
+	return fmt.Sprintf("%d;", uid)
+}
+
+func (h *hbaseStore) Enqueue(ctx context.Context, uid types.ID, payloads ...[]byte) (int64, error) {
+	if len(payloads) == 0 {
+		return 0, nil
+	}
+
+	res := h.client.Context(ctx).Table(h.opts.table).Key(seqRowKey(uid)).
+		Family(hbaseFamily).Qualifier(hbaseQualifierSeq).Amount(int64(len(payloads))).Increment()
+	if err := res.Err(); err != nil {
+		return 0, err
+	}
+
+	last := res.Int64()
+	for i, p := range payloads {
+		seq := last - int64(len(payloads)-1-i)
+		values := map[string]map[string][]byte{hbaseFamily: {hbaseQualifierMsg: p}}
+		err := h.client.Context(ctx).Table(h.opts.table).Key(msgRowKey(uid, seq)).Values(values).
+			Options(hrpc.TTL(h.opts.expire)).Put().Err()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return last, h.trim(ctx, uid, last, len(payloads))
+}
+
+// trim deletes the n rows pushed out of max members by enqueuing n messages up to seq last.
+// Rows before them are trimmed by former Enqueue, deleting missing rows is a no-op.
+func (h *hbaseStore) trim(ctx context.Context, uid types.ID, last int64, n int) error {
+	end := last - int64(h.opts.maxMembers)
+	for seq := end - int64(n) + 1; seq <= end; seq++ {
+		if seq <= 0 {
+			continue
+		}
+
+		if err := h.delete(ctx, uid, seq); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *hbaseStore) delete(ctx context.Context, uid types.ID, seq int64) error {
+	return h.client.Context(ctx).Table(h.opts.table).Key(msgRowKey(uid, seq)).Delete().Err()
+}
+
+func (h *hbaseStore) scan(ctx context.Context, uid types.ID, after int64, f func(r *hrpc.Result) bool,
+	opts ...func(hrpc.Call) error) error {
+	res := h.client.Context(ctx).Table(h.opts.table).Range(msgRowKey(uid, after+1), msgRowEnd(uid)).
+		Options(opts...).Scan()
+	if err := res.Err(); err != nil {
+		return err
+	}
+
+	scanner := res.Scanner()
+	defer scanner.Close()
+
+	for {
+		r, err := scanner.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(r.Cells) > 0 && !f(r) {
+			return nil
+		}
+	}
+}
+
+// scanSeqs returns seqs of all messages of uid without payload.
+func (h *hbaseStore) scanSeqs(ctx context.Context, uid types.ID) ([]int64, error) {
+	var (
+		seqs     []int64
+		parseErr error
+	)
+	err := h.scan(ctx, uid, 0, func(r *hrpc.Result) bool {
+		seq, err := parseSeq(uid, r.Cells[0].Row)
+		if err != nil {
+			parseErr = err
+			return false
+		}
+
+		seqs = append(seqs, seq)
+		return true
+	}, hrpc.Filters(filter.NewKeyOnlyFilter(true)))
+	if err != nil {
+		return nil, err
+	}
+
+	return seqs, parseErr
+}
+
+func parseSeq(uid types.ID, row []byte) (int64, error) {
+	var (
+		u   int64
+		seq int64
+	)
+	if _, err := fmt.Sscanf(string(row), "%d:%d", &u, &seq); err != nil || u != uid.Int64() {
+		return 0, fmt.Errorf("invalid offline row: %q", row)
+	}
+
+	return seq, nil
+}
+
+func (h *hbaseStore) Fetch(ctx context.Context, uid types.ID, after int64, limit int) ([]*Message, error) {
+	var (
+		messages []*Message
+		parseErr error
+	)
+	err := h.scan(ctx, uid, after, func(r *hrpc.Result) bool {
+		seq, err := parseSeq(uid, r.Cells[0].Row)
+		if err != nil {
+			parseErr = err
+			return false
+		}
+
+		messages = append(messages, &Message{Seq: seq, Payload: r.Cells[0].Value})
+		return limit <= 0 || len(messages) < limit
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return messages, parseErr
+}
+
+func (h *hbaseStore) Ack(ctx context.Context, uid types.ID, seq int64) error {
+	seqs, err := h.scanSeqs(ctx, uid)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range seqs {
+		if s > seq {
+			break
+		}
+
+		if err = h.delete(ctx, uid, s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *hbaseStore) Count(ctx context.Context, uid types.ID) (int64, error) {
+	seqs, err := h.scanSeqs(ctx, uid)
+	return int64(len(seqs)), err
+}
@@ -0,0 +1,108 @@
+package offline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-goim/core/pkg/types"
+)
+
+// memoryStore keeps queues in memory, it is for tests and single node deployment.
+type memoryStore struct {
+	lock   sync.Mutex
+	opts   *options
+	queues map[types.ID]*memoryQueue
+}
+
+type memoryQueue struct {
+	seq      int64
+	messages []*Message
+	expireAt time.Time
+}
+
+var _ Store = &memoryStore{}
+
+// NewMemoryStore creates Store backed by memory.
+func NewMemoryStore(opts ...Option) Store {
+	return &memoryStore{
+		opts:   newOptions(opts...),
+		queues: make(map[types.ID]*memoryQueue),
+	}
+}
+
+// queue returns queue of uid, expired messages are dropped but seq is kept like redis store.
+func (m *memoryStore) queue(uid types.ID) *memoryQueue {
+	q, ok := m.queues[uid]
+	if !ok {
+		q = &memoryQueue{}
+		m.queues[uid] = q
+	}
+
+	if !q.expireAt.IsZero() && time.Now().After(q.expireAt) {
+		q.messages = nil
+		q.expireAt = time.Time{}
+	}
+
+	return q
+}
+
+func (m *memoryStore) Enqueue(_ context.Context, uid types.ID, payloads ...[]byte) (int64, error) {
+	if len(payloads) == 0 {
+		return 0, nil
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	q := m.queue(uid)
+	for _, p := range payloads {
+		q.seq++
+		q.messages = append(q.messages, &Message{Seq: q.seq, Payload: p})
+	}
+
+	if n := len(q.messages) - m.opts.maxMembers; n > 0 {
+		q.messages = append([]*Message(nil), q.messages[n:]...)
+	}
+	q.expireAt = time.Now().Add(m.opts.expire)
+
+	return q.seq, nil
+}
+
+func (m *memoryStore) Fetch(_ context.Context, uid types.ID, after int64, limit int) ([]*Message, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var messages []*Message
+	for _, msg := range m.queue(uid).messages {
+		if limit > 0 && len(messages) >= limit {
+			break
+		}
+		if msg.Seq > after {
+			messages = append(messages, msg)
+		}
+	}
+
+	return messages, nil
+}
+
+func (m *memoryStore) Ack(_ context.Context, uid types.ID, seq int64) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	q := m.queue(uid)
+	i := 0
+	for i < len(q.messages) && q.messages[i].Seq <= seq {
+		i++
+	}
+	q.messages = q.messages[i:]
+
+	return nil
+}
+
+func (m *memoryStore) Count(_ context.Context, uid types.ID) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return int64(len(m.queue(uid).messages)), nil
+}
@@ -0,0 +1,98 @@
+package offline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redisv8 "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/go-goim/core/pkg/consts"
+	"github.com/go-goim/core/pkg/types"
+)
+
+func TestMemoryStore(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		store = NewMemoryStore(WithMaxMembers(3), WithExpire(100*time.Millisecond))
+		uid   = types.ID(1001)
+	)
+
+	seq, err := store.Enqueue(ctx, uid, []byte("1"), []byte("2"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), seq)
+
+	// oldest one is dropped beyond max members
+	assert.NoError(t, Fallback(store)(ctx, uid, []byte("3")))
+	_, err = store.Enqueue(ctx, uid, []byte("4"))
+	assert.NoError(t, err)
+
+	count, err := store.Count(ctx, uid)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	messages, err := store.Fetch(ctx, uid, 2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Message{{Seq: 3, Payload: []byte("3")}}, messages)
+
+	assert.NoError(t, store.Ack(ctx, uid, 3))
+	messages, err = store.Fetch(ctx, uid, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Message{{Seq: 4, Payload: []byte("4")}}, messages)
+
+	// seq keeps increasing after queue expired
+	time.Sleep(150 * time.Millisecond)
+	count, err = store.Count(ctx, uid)
+	assert.NoError(t, err)
+	assert.Zero(t, count)
+
+	seq, err = store.Enqueue(ctx, uid, []byte("5"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), seq)
+}
+
+func TestRedisStore(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		mr    = miniredis.RunT(t)
+		store = NewRedisStore(redisv8.NewClient(&redisv8.Options{Addr: mr.Addr()}), WithMaxMembers(3), WithExpire(time.Minute))
+		uid   = types.ID(1002)
+		key   = consts.GetUserOfflineQueueKey(uid.Int64())
+		sk    = seqKey(key)
+	)
+
+	// seq key is in the same cluster slot as queue key, whose hash tag is the whole key
+	assert.Equal(t, "userOfflineQueue:1002", key)
+	assert.Equal(t, "{userOfflineQueue:1002}:seq", sk)
+
+	first, err := store.Enqueue(ctx, uid, []byte("1"), []byte("2"))
+	assert.NoError(t, err)
+	_, err = store.Enqueue(ctx, uid, []byte("3"), []byte("4"))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, mr.TTL(key))
+	assert.Equal(t, time.Minute, mr.TTL(sk))
+
+	count, err := store.Count(ctx, uid)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), count)
+
+	messages, err := store.Fetch(ctx, uid, first, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Message{{Seq: first + 1, Payload: []byte("3")}}, messages)
+
+	assert.NoError(t, store.Ack(ctx, uid, first+1))
+	messages, err = store.Fetch(ctx, uid, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Message{{Seq: first + 2, Payload: []byte("4")}}, messages)
+
+	// seq keeps increasing after both keys expired
+	mr.FastForward(time.Minute)
+	assert.False(t, mr.Exists(key))
+	assert.False(t, mr.Exists(sk))
+	time.Sleep(10 * time.Millisecond)
+	seq, err := store.Enqueue(ctx, uid, []byte("5"))
+	assert.NoError(t, err)
+	assert.Greater(t, seq, first+2)
+}
@@ -0,0 +1,60 @@
+package offline
+
+import (
+	"time"
+
+	"github.com/go-goim/core/pkg/consts"
+)
+
+type options struct {
+	maxMembers int
+	expire     time.Duration
+	table      string
+}
+
+const (
+	defaultTable = "offline_queue"
+)
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		maxMembers: consts.UserOfflineQueueMemberMax,
+		expire:     consts.UserOfflineQueueKeyExpire,
+		table:      defaultTable,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+type Option func(o *options)
+
+// WithMaxMembers sets max count of messages kept in queue of a user.
+func WithMaxMembers(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.maxMembers = n
+		}
+	}
+}
+
+// WithExpire sets how long queue of a user is kept since the last Enqueue.
+func WithExpire(expire time.Duration) Option {
+	return func(o *options) {
+		if expire > 0 {
+			o.expire = expire
+		}
+	}
+}
+
+// WithTable sets hbase table of queues, only used by hbase store.
+func WithTable(table string) Option {
+	return func(o *options) {
+		if table != "" {
+			o.table = table
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package offline
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	redisv8 "github.com/go-redis/redis/v8"
+
+	"github.com/go-goim/core/pkg/consts"
+	"github.com/go-goim/core/pkg/types"
+)
+
+// enqueue appends ARGV[4:] to sorted set KEYS[1] scored by seq from KEYS[2], then trims it to
+// ARGV[2] members and expires both keys after ARGV[1] seconds. Member is "seq:payload" so that the
+// same payload can be queued twice. Seq starts from ARGV[3], which is current unix micro, when KEYS[2]
+// is missing, so that it keeps increasing after expired.
+var enqueue = redisv8.NewScript(`
+if redis.call("EXISTS", KEYS[2]) == 0 then
+	redis.call("SET", KEYS[2], ARGV[3])
+end
+local seq = 0
+for i = 4, #ARGV do
+	seq = redis.call("INCR", KEYS[2])
+	redis.call("ZADD", KEYS[1], seq, seq .. ":" .. ARGV[i])
+end
+redis.call("ZREMRANGEBYRANK", KEYS[1], 0, -tonumber(ARGV[2]) - 1)
+redis.call("EXPIRE", KEYS[1], ARGV[1])
+redis.call("EXPIRE", KEYS[2], ARGV[1])
+return seq
+`)
+
+// seqKey returns key of seq of queue key. Queue key is its hash tag, so both keys of a user are in
+// the same slot of redis cluster.
+func seqKey(queueKey string) string {
+	return "{" + queueKey + "}:seq"
+}
+
+// redisStore keeps queue in sorted set described by consts.UserOfflineQueueKeyPrefix, and seq of it
+// in key returned by seqKey.
+type redisStore struct {
+	client *redisv8.Client
+	opts   *options
+}
+
+var _ Store = &redisStore{}
+
+// NewRedisStore creates Store backed by redis.
+func NewRedisStore(cli *redisv8.Client, opts ...Option) Store {
+	return &redisStore{
+		client: cli,
+		opts:   newOptions(opts...),
+	}
+}
+
+func (r *redisStore) Enqueue(ctx context.Context, uid types.ID, payloads ...[]byte) (int64, error) {
+	if len(payloads) == 0 {
+		return 0, nil
+	}
+
+	args := make([]interface{}, 0, len(payloads)+3)
+	args = append(args, int64(r.opts.expire.Seconds()), r.opts.maxMembers, time.Now().UnixMicro())
+	for _, p := range payloads {
+		args = append(args, p)
+	}
+
+	key := consts.GetUserOfflineQueueKey(uid.Int64())
+	keys := []string{key, seqKey(key)}
+	return enqueue.Run(ctx, r.client, keys, args...).Int64()
+}
+
+func (r *redisStore) Fetch(ctx context.Context, uid types.ID, after int64, limit int) ([]*Message, error) {
+	by := &redisv8.ZRangeBy{
+		Min: "(" + strconv.FormatInt(after, 10),
+		Max: "+inf",
+	}
+	if limit > 0 {
+		by.Count = int64(limit)
+	}
+
+	members, err := r.client.ZRangeByScore(ctx, consts.GetUserOfflineQueueKey(uid.Int64()), by).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]*Message, 0, len(members))
+	for _, member := range members {
+		m, err := decodeMember(member)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, m)
+	}
+
+	return messages, nil
+}
+
+func (r *redisStore) Ack(ctx context.Context, uid types.ID, seq int64) error {
+	return r.client.ZRemRangeByScore(ctx, consts.GetUserOfflineQueueKey(uid.Int64()),
+		"-inf", strconv.FormatInt(seq, 10)).Err()
+}
+
+func (r *redisStore) Count(ctx context.Context, uid types.ID) (int64, error) {
+	return r.client.ZCard(ctx, consts.GetUserOfflineQueueKey(uid.Int64())).Result()
+}
+
+func decodeMember(member string) (*Message, error) {
+	i := strings.IndexByte(member, ':')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid offline member: %q", member)
+	}
+
+	seq, err := strconv.ParseInt(member[:i], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid offline member seq: %w", err)
+	}
+
+	return &Message{Seq: seq, Payload: []byte(member[i+1:])}, nil
+}
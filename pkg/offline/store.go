@@ -0,0 +1,38 @@
+package offline
+
+import (
+	"context"
+
+	"github.com/go-goim/core/pkg/conn/ws"
+	"github.com/go-goim/core/pkg/types"
+)
+
+// Message is a message parked in offline queue of a user.
+type Message struct {
+	// Seq increases in queue of the user, it is used as cursor of Fetch and Ack.
+	Seq     int64
+	Payload []byte
+}
+
+// Store keeps the latest messages of users who are not connected. Queue of a user keeps at most
+// consts.UserOfflineQueueMemberMax messages by default, older ones are dropped, and whole queue
+// expires after consts.UserOfflineQueueKeyExpire since the last Enqueue.
+type Store interface {
+	// Enqueue appends payloads to queue of uid, returns seq of the last one.
+	Enqueue(ctx context.Context, uid types.ID, payloads ...[]byte) (int64, error)
+	// Fetch returns at most limit messages whose seq is greater than after, in order of seq.
+	// All messages are returned if limit <= 0.
+	Fetch(ctx context.Context, uid types.ID, after int64, limit int) ([]*Message, error)
+	// Ack removes messages whose seq is not greater than seq.
+	Ack(ctx context.Context, uid types.ID, seq int64) error
+	// Count returns count of messages in queue of uid.
+	Count(ctx context.Context, uid types.ID) (int64, error)
+}
+
+// Fallback returns ws.OfflineFallback which parks lost frames in store.
+func Fallback(store Store) ws.OfflineFallback {
+	return func(ctx context.Context, uid types.ID, payload []byte) error {
+		_, err := store.Enqueue(ctx, uid, payload)
+		return err
+	}
+}
@@ -0,0 +1,116 @@
+package presence
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-goim/core/pkg/types"
+)
+
+// memoryStore keeps presence in memory and publishes events in process, it is for tests
+// and single node deployment.
+type memoryStore struct {
+	lock        sync.Mutex
+	conns       map[types.ID]map[string]memoryConn // uid -> field of conn -> conn
+	subscribers map[int]func(e *Event)
+	nextID      int
+}
+
+type memoryConn struct {
+	agent    string
+	expireAt time.Time
+}
+
+var _ Store = &memoryStore{}
+
+// NewMemoryStore creates Store backed by memory.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		conns:       make(map[types.ID]map[string]memoryConn),
+		subscribers: make(map[int]func(e *Event)),
+	}
+}
+
+func (m *memoryStore) SetOnline(_ context.Context, ttl time.Duration, conns ...ConnInfo) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	expireAt := time.Now().Add(ttl)
+	for _, c := range conns {
+		if m.conns[c.UID] == nil {
+			m.conns[c.UID] = make(map[string]memoryConn)
+		}
+		m.conns[c.UID][c.field()] = memoryConn{agent: c.Agent, expireAt: expireAt}
+	}
+
+	return nil
+}
+
+func (m *memoryStore) SetOffline(_ context.Context, conns ...ConnInfo) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, c := range conns {
+		delete(m.conns[c.UID], c.field())
+		if len(m.conns[c.UID]) == 0 {
+			delete(m.conns, c.UID)
+		}
+	}
+
+	return nil
+}
+
+func (m *memoryStore) WhereIs(_ context.Context, uids ...types.ID) (map[types.ID]string, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	result := make(map[types.ID]string, len(uids))
+	for _, uid := range uids {
+		var (
+			picked   string
+			pickedAt int64
+		)
+		for _, c := range m.conns[uid] {
+			if c.expireAt.After(now) && preferConn(c.agent, c.expireAt.UnixMilli(), picked, pickedAt) {
+				picked, pickedAt = c.agent, c.expireAt.UnixMilli()
+			}
+		}
+		if picked != "" {
+			result[uid] = picked
+		}
+	}
+
+	return result, nil
+}
+
+func (m *memoryStore) Publish(_ context.Context, e *Event) error {
+	m.lock.Lock()
+	subscribers := make([]func(e *Event), 0, len(m.subscribers))
+	for _, f := range m.subscribers {
+		subscribers = append(subscribers, f)
+	}
+	m.lock.Unlock()
+
+	for _, f := range subscribers {
+		f(e)
+	}
+
+	return nil
+}
+
+func (m *memoryStore) Subscribe(ctx context.Context, f func(e *Event)) error {
+	m.lock.Lock()
+	id := m.nextID
+	m.nextID++
+	m.subscribers[id] = f
+	m.lock.Unlock()
+
+	<-ctx.Done()
+
+	m.lock.Lock()
+	delete(m.subscribers, id)
+	m.lock.Unlock()
+	return nil
+}
@@ -0,0 +1,14 @@
+package presence
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/go-goim/core/pkg/metrics"
+)
+
+var droppedChanges = prometheus.NewCounter(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Subsystem: "presence",
+	Name:      "dropped_changes_total",
+	Help:      "Total number of presence changes dropped since sync queue is full, they are synced by next refresh.",
+})
@@ -0,0 +1,70 @@
+package presence
+
+import (
+	"os"
+	"time"
+
+	"github.com/go-goim/core/pkg/consts"
+)
+
+type options struct {
+	agent      string
+	ttl        time.Duration
+	queueSize  int
+	batchSize  int
+	retryDelay time.Duration
+}
+
+const (
+	defaultQueueSize  = 4096
+	defaultBatchSize  = 500
+	defaultRetryDelay = time.Second
+)
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		ttl:        consts.UserOnlineAgentKeyExpire,
+		queueSize:  defaultQueueSize,
+		batchSize:  defaultBatchSize,
+		retryDelay: defaultRetryDelay,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.agent == "" {
+		o.agent, _ = os.Hostname()
+	}
+
+	return o
+}
+
+type Option func(o *options)
+
+// WithAgent sets address of this push server, which is saved as where users are connected to.
+// Hostname is used if not set.
+func WithAgent(addr string) Option {
+	return func(o *options) {
+		o.agent = addr
+	}
+}
+
+// WithTTL sets how long online status is kept without refreshing, it is refreshed every third of ttl.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		if ttl > 0 {
+			o.ttl = ttl
+		}
+	}
+}
+
+// WithQueueSize sets size of queue of changes waiting to sync. Changes are dropped if the queue is full,
+// and synced by next refresh.
+func WithQueueSize(size int) Option {
+	return func(o *options) {
+		if size > 0 {
+			o.queueSize = size
+		}
+	}
+}
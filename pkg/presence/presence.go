@@ -0,0 +1,382 @@
+package presence
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-goim/core/pkg/conn/ws"
+	"github.com/go-goim/core/pkg/graceful"
+	"github.com/go-goim/core/pkg/log"
+	"github.com/go-goim/core/pkg/metrics"
+	"github.com/go-goim/core/pkg/types"
+)
+
+var (
+	ErrNotInitialized = errors.New("presence not initialized")
+)
+
+var defaultPresence *Presence
+
+// Init creates Presence with store, registers it as observer of all connections and closes it
+// on graceful shutdown. Package functions use the Presence created by Init.
+func Init(store Store, opts ...Option) *Presence {
+	p := New(store, opts...)
+	ws.RegisterObserver(p)
+	graceful.Register(p.Close)
+	defaultPresence = p
+	return p
+}
+
+// IsOnline is wrapper for default Presence.IsOnline.
+func IsOnline(ctx context.Context, uids ...types.ID) (map[types.ID]bool, error) {
+	if defaultPresence == nil {
+		return nil, ErrNotInitialized
+	}
+
+	return defaultPresence.IsOnline(ctx, uids...)
+}
+
+// WhereIs is wrapper for default Presence.WhereIs.
+func WhereIs(ctx context.Context, uids ...types.ID) (map[types.ID]string, error) {
+	if defaultPresence == nil {
+		return nil, ErrNotInitialized
+	}
+
+	return defaultPresence.WhereIs(ctx, uids...)
+}
+
+// Watch is wrapper for default Presence.Watch.
+func Watch(uid types.ID, f func(e *Event)) (cancel func()) {
+	if defaultPresence == nil {
+		return func() {}
+	}
+
+	return defaultPresence.Watch(uid, f)
+}
+
+// Presence keeps connections of users connected to this agent in store. It implements ws.Observer,
+// user goes online when the first connection of the user is added to pool, and goes offline when
+// the last one is closed, so multiple devices of a user are counted as one.
+type Presence struct {
+	ws.BaseObserver
+	opts  *options
+	store Store
+
+	lock     sync.Mutex
+	local    map[types.ID]map[ws.Conn]ConnInfo // uid -> connections at this agent
+	idPrefix string
+	nextID   uint64
+
+	changes chan types.ID
+	online  map[types.ID]map[string]ConnInfo // uid -> id -> connections set online in store, only accessed by syncDaemon
+
+	watchLock sync.RWMutex
+	watchers  map[types.ID]map[uint64]func(e *Event)
+	watchID   uint64
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates Presence and starts syncing status to store and receiving events from store.
+func New(store Store, opts ...Option) *Presence {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Presence{
+		opts:     newOptions(opts...),
+		store:    store,
+		local:    make(map[types.ID]map[ws.Conn]ConnInfo),
+		idPrefix: strconv.FormatInt(time.Now().UnixNano(), 36),
+		online:   make(map[types.ID]map[string]ConnInfo),
+		watchers: make(map[types.ID]map[uint64]func(e *Event)),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	p.changes = make(chan types.ID, p.opts.queueSize)
+	metrics.Register(droppedChanges)
+
+	p.wg.Add(2)
+	go p.syncDaemon()
+	go p.subscribeDaemon()
+	return p
+}
+
+// OnConnect implements ws.Observer.
+func (p *Presence) OnConnect(c ws.Conn) {
+	p.lock.Lock()
+	p.nextID++
+	conns := p.local[c.UID()]
+	if conns == nil {
+		conns = make(map[ws.Conn]ConnInfo)
+		p.local[c.UID()] = conns
+	}
+	// id is unique among restarts of agent, so connections left by last run are never taken as new ones
+	conns[c] = ConnInfo{UID: c.UID(), Agent: p.opts.agent, ID: p.idPrefix + "-" + strconv.FormatUint(p.nextID, 10)}
+	p.lock.Unlock()
+
+	p.changed(c.UID())
+}
+
+// OnClose implements ws.Observer.
+func (p *Presence) OnClose(c ws.Conn, _ error) {
+	p.lock.Lock()
+	delete(p.local[c.UID()], c)
+	if len(p.local[c.UID()]) == 0 {
+		delete(p.local, c.UID())
+	}
+	p.lock.Unlock()
+
+	p.changed(c.UID())
+}
+
+// changed queues uid to sync, it is called on connect path so never blocks. uid is dropped if the
+// queue is full, e.g. store is slow, and synced by next refresh.
+func (p *Presence) changed(uid types.ID) {
+	select {
+	case p.changes <- uid:
+	default:
+		droppedChanges.Inc()
+	}
+}
+
+func (p *Presence) isLocal(uid types.ID) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.local[uid]) > 0
+}
+
+// localConns returns connections of uid at this agent by id.
+func (p *Presence) localConns(uid types.ID) map[string]ConnInfo {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	conns := make(map[string]ConnInfo, len(p.local[uid]))
+	for _, c := range p.local[uid] {
+		conns[c.ID] = c
+	}
+
+	return conns
+}
+
+// IsOnline reports whether uids are online at any agent.
+func (p *Presence) IsOnline(ctx context.Context, uids ...types.ID) (map[types.ID]bool, error) {
+	agents, err := p.store.WhereIs(ctx, uids...)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make(map[types.ID]bool, len(uids))
+	for _, uid := range uids {
+		_, result[uid] = agents[uid]
+	}
+
+	return result, nil
+}
+
+// WhereIs returns agents uids are connected to, uids not online are not in the result.
+func (p *Presence) WhereIs(ctx context.Context, uids ...types.ID) (map[types.ID]string, error) {
+	return p.store.WhereIs(ctx, uids...)
+}
+
+// Watch calls f with online and offline events of uid published by all agents, until cancel is called.
+// f is called in order of events, it should not block.
+func (p *Presence) Watch(uid types.ID, f func(e *Event)) (cancel func()) {
+	p.watchLock.Lock()
+	defer p.watchLock.Unlock()
+
+	p.watchID++
+	id := p.watchID
+	if p.watchers[uid] == nil {
+		p.watchers[uid] = make(map[uint64]func(e *Event))
+	}
+	p.watchers[uid][id] = f
+
+	return func() {
+		p.watchLock.Lock()
+		defer p.watchLock.Unlock()
+		delete(p.watchers[uid], id)
+		if len(p.watchers[uid]) == 0 {
+			delete(p.watchers, uid)
+		}
+	}
+}
+
+func (p *Presence) dispatch(e *Event) {
+	p.watchLock.RLock()
+	watchers := make([]func(e *Event), 0, len(p.watchers[e.UID]))
+	for _, f := range p.watchers[e.UID] {
+		watchers = append(watchers, f)
+	}
+	p.watchLock.RUnlock()
+
+	for _, f := range watchers {
+		f(e)
+	}
+}
+
+// syncDaemon syncs changed uids to store, and refreshes all local uids every third of ttl.
+func (p *Presence) syncDaemon() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.opts.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case uid := <-p.changes:
+			p.sync(uid)
+		case <-ticker.C:
+			p.refresh()
+		}
+	}
+}
+
+// sync makes connections of uid in store same as local, failed ones are retried by refresh.
+// Online event is published when the first connection of uid at this agent is set online,
+// and offline event when the last one is removed.
+func (p *Presence) sync(uid types.ID) {
+	var (
+		want        = p.localConns(uid)
+		have        = p.online[uid]
+		add, remove []ConnInfo
+	)
+	for id, c := range want {
+		if _, ok := have[id]; !ok {
+			add = append(add, c)
+		}
+	}
+	for id, c := range have {
+		if _, ok := want[id]; !ok {
+			remove = append(remove, c)
+		}
+	}
+	if len(add) == 0 && len(remove) == 0 {
+		return
+	}
+
+	wasOnline := len(have) > 0
+	if have == nil {
+		have = make(map[string]ConnInfo)
+	}
+	if len(add) > 0 {
+		if err := p.store.SetOnline(p.ctx, p.opts.ttl, add...); err != nil {
+			log.Error("presence sync error", "uid", uid, "type", EventOnline, "error", err)
+			return
+		}
+		for _, c := range add {
+			have[c.ID] = c
+		}
+	}
+	if len(remove) > 0 {
+		if err := p.store.SetOffline(p.ctx, remove...); err != nil {
+			log.Error("presence sync error", "uid", uid, "type", EventOffline, "error", err)
+		} else {
+			for _, c := range remove {
+				delete(have, c.ID)
+			}
+		}
+	}
+
+	if len(have) == 0 {
+		delete(p.online, uid)
+	} else {
+		p.online[uid] = have
+	}
+
+	switch online := len(have) > 0; {
+	case online && !wasOnline:
+		p.publish(p.ctx, uid, EventOnline)
+	case !online && wasOnline:
+		p.publish(p.ctx, uid, EventOffline)
+	}
+}
+
+// refresh extends ttl of all local connections and retries failed sync.
+func (p *Presence) refresh() {
+	p.lock.Lock()
+	uids := make([]types.ID, 0, len(p.local))
+	conns := make([]ConnInfo, 0, len(p.local))
+	for uid, cs := range p.local {
+		uids = append(uids, uid)
+		for _, c := range cs {
+			conns = append(conns, c)
+		}
+	}
+	p.lock.Unlock()
+
+	for start := 0; start < len(conns); start += p.opts.batchSize {
+		end := start + p.opts.batchSize
+		if end > len(conns) {
+			end = len(conns)
+		}
+
+		if err := p.store.SetOnline(p.ctx, p.opts.ttl, conns[start:end]...); err != nil {
+			log.Error("presence refresh error", "count", end-start, "error", err)
+		}
+	}
+
+	for _, uid := range uids {
+		p.sync(uid)
+	}
+	for uid := range p.online {
+		if !p.isLocal(uid) {
+			p.sync(uid)
+		}
+	}
+}
+
+func (p *Presence) publish(ctx context.Context, uid types.ID, typ EventType) {
+	e := &Event{
+		UID:   uid,
+		Type:  typ,
+		Agent: p.opts.agent,
+		Time:  time.Now().UnixMilli(),
+	}
+	if err := p.store.Publish(ctx, e); err != nil {
+		log.Error("presence publish error", "uid", uid, "type", typ, "error", err)
+	}
+}
+
+// subscribeDaemon dispatches events from store to watchers, it resubscribes if failed.
+func (p *Presence) subscribeDaemon() {
+	defer p.wg.Done()
+	for {
+		err := p.store.Subscribe(p.ctx, p.dispatch)
+		if p.ctx.Err() != nil {
+			return
+		}
+
+		log.Error("presence subscribe error", "error", err)
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-time.After(p.opts.retryDelay):
+		}
+	}
+}
+
+// Close stops syncing and removes all connections of this agent from store.
+func (p *Presence) Close(ctx context.Context) error {
+	p.cancel()
+	p.wg.Wait()
+
+	var conns []ConnInfo
+	for _, cs := range p.online {
+		for _, c := range cs {
+			conns = append(conns, c)
+		}
+	}
+	if err := p.store.SetOffline(ctx, conns...); err != nil {
+		return err
+	}
+
+	for uid := range p.online {
+		p.publish(ctx, uid, EventOffline)
+	}
+	p.online = make(map[types.ID]map[string]ConnInfo)
+	return nil
+}
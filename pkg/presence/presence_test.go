@@ -0,0 +1,177 @@
+package presence
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redisv8 "github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/go-goim/core/pkg/conn/ws"
+	"github.com/go-goim/core/pkg/types"
+)
+
+type testConn struct {
+	ws.Conn
+	uid types.ID
+}
+
+func (c *testConn) UID() types.ID {
+	return c.uid
+}
+
+func TestPresence(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		store := NewMemoryStore()
+		testPresence(t, store, func() int {
+			m := store.(*memoryStore)
+			m.lock.Lock()
+			defer m.lock.Unlock()
+			return len(m.subscribers)
+		})
+	})
+
+	t.Run("redis", func(t *testing.T) {
+		mr := miniredis.RunT(t)
+		testPresence(t, NewRedisStore(redisv8.NewClient(&redisv8.Options{Addr: mr.Addr()})), func() int {
+			return mr.PubSubNumSub(EventChannel)[EventChannel]
+		})
+	})
+}
+
+func testPresence(t *testing.T, store Store, subscribers func() int) {
+	var (
+		ctx = context.Background()
+		a   = New(store, WithAgent("A"))
+		b   = New(store, WithAgent("B"))
+		uid = types.ID(1001)
+	)
+	defer b.Close(ctx) // nolint: errcheck
+
+	// wait for both subscribed
+	assert.Eventually(t, func() bool { return subscribers() == 2 }, time.Second, 10*time.Millisecond)
+
+	var (
+		lock   sync.Mutex
+		events []*Event
+	)
+	cancel := b.Watch(uid, func(e *Event) {
+		lock.Lock()
+		events = append(events, e)
+		lock.Unlock()
+	})
+	defer cancel()
+	eventCount := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return len(events)
+	}
+
+	isOnline := func(uid types.ID) bool {
+		online, err := b.IsOnline(ctx, uid)
+		assert.NoError(t, err)
+		return online[uid]
+	}
+
+	// two devices are counted as one
+	phone, pc := &testConn{uid: uid}, &testConn{uid: uid}
+	a.OnConnect(phone)
+	a.OnConnect(pc)
+	assert.Eventually(t, func() bool { return isOnline(uid) }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return eventCount() == 1 }, time.Second, 10*time.Millisecond)
+
+	agents, err := b.WhereIs(ctx, uid, 1002)
+	assert.NoError(t, err)
+	assert.Equal(t, map[types.ID]string{uid: "A"}, agents)
+
+	a.OnClose(phone, nil)
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, isOnline(uid))
+
+	a.OnClose(pc, nil)
+	assert.Eventually(t, func() bool { return !isOnline(uid) }, time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool { return eventCount() == 2 }, time.Second, 10*time.Millisecond)
+
+	lock.Lock()
+	assert.Equal(t, EventOnline, events[0].Type)
+	assert.Equal(t, EventOffline, events[1].Type)
+	assert.Equal(t, "A", events[1].Agent)
+	lock.Unlock()
+
+	// user reconnected to B is not removed by late disconnect at A
+	a.OnConnect(phone)
+	assert.Eventually(t, func() bool { return isOnline(uid) }, time.Second, 10*time.Millisecond)
+	b.OnConnect(pc)
+	time.Sleep(50 * time.Millisecond)
+	a.OnClose(phone, nil)
+	assert.Eventually(t, func() bool {
+		agents, _ := b.WhereIs(ctx, uid)
+		return agents[uid] == "B"
+	}, time.Second, 10*time.Millisecond)
+
+	a.OnConnect(phone)
+	time.Sleep(50 * time.Millisecond)
+
+	assert.NoError(t, a.Close(ctx))
+	agents, err = b.WhereIs(ctx, uid)
+	assert.NoError(t, err)
+	assert.Equal(t, "B", agents[uid])
+}
+
+// slowStore blocks SetOnline until release is closed.
+type slowStore struct {
+	Store
+	release chan struct{}
+}
+
+func (s *slowStore) SetOnline(ctx context.Context, ttl time.Duration, conns ...ConnInfo) error {
+	select {
+	case <-s.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return s.Store.SetOnline(ctx, ttl, conns...)
+}
+
+func TestPresence_SlowStore(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		store = &slowStore{Store: NewMemoryStore(), release: make(chan struct{})}
+		p     = New(store, WithAgent("A"), WithQueueSize(1), WithTTL(300*time.Millisecond))
+		uids  = []types.ID{2001, 2002, 2003, 2004}
+	)
+	defer p.Close(ctx) // nolint: errcheck
+
+	dropped := testutil.ToFloat64(droppedChanges)
+	done := make(chan struct{})
+	go func() {
+		for _, uid := range uids {
+			p.OnConnect(&testConn{uid: uid})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("connect blocked by slow store")
+	}
+	assert.Greater(t, testutil.ToFloat64(droppedChanges), dropped)
+
+	// dropped changes are synced by refresh
+	close(store.release)
+	assert.Eventually(t, func() bool {
+		online, err := p.IsOnline(ctx, uids...)
+		assert.NoError(t, err)
+		for _, uid := range uids {
+			if !online[uid] {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 20*time.Millisecond)
+}
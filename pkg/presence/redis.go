@@ -0,0 +1,221 @@
+package presence
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	redisv8 "github.com/go-redis/redis/v8"
+
+	"github.com/go-goim/core/pkg/consts"
+	"github.com/go-goim/core/pkg/log"
+	"github.com/go-goim/core/pkg/types"
+)
+
+// EventChannel is the redis pub/sub channel of presence events.
+const EventChannel = "presence:events"
+
+// onlineConnsKeyPrefix is key of hash of online connections of user, it is internal to redisStore.
+// The field is agent and id of connection and the value is "expireAt:agent", expireAt is unix ms.
+const onlineConnsKeyPrefix = "userOnlineConns:%d"
+
+func onlineConnsKey(uid types.ID) string {
+	return fmt.Sprintf(onlineConnsKeyPrefix, uid.Int64())
+}
+
+// syncAgent removes fields of hash KEYS[1] expired before ARGV[1], e.g. ones left by crashed agents,
+// and then points KEYS[2] to agent of the most recently refreshed connection until it expires,
+// or deletes KEYS[2] if no connection is left. Ties go to the smaller agent as memoryStore does.
+const syncAgent = `
+local now = tonumber(ARGV[1])
+local agent, expireAt = nil, 0
+local all = redis.call("HGETALL", KEYS[1])
+for i = 1, #all, 2 do
+	local at, a = string.match(all[i + 1], "^(%d+):(.*)$")
+	at = tonumber(at)
+	if at == nil or at < now then
+		redis.call("HDEL", KEYS[1], all[i])
+	elseif agent == nil or at > expireAt or (at == expireAt and a < agent) then
+		agent, expireAt = a, at
+	end
+end
+if agent == nil then
+	redis.call("DEL", KEYS[2])
+elseif expireAt > now then
+	redis.call("SET", KEYS[2], agent, "PX", expireAt - now)
+end
+`
+
+// setOnline sets fields ARGV[3], ARGV[5]... of hash KEYS[1] to values ARGV[4], ARGV[6]... and syncs agent key
+// KEYS[2]. The hash expires after ARGV[2] ms only if no connection of the user is refreshed.
+var setOnline = redisv8.NewScript(`
+for i = 3, #ARGV, 2 do
+	redis.call("HSET", KEYS[1], ARGV[i], ARGV[i + 1])
+end
+` + syncAgent + `
+if redis.call("PTTL", KEYS[1]) < tonumber(ARGV[2]) then
+	redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// setOffline removes fields ARGV[2], ARGV[3]... of hash KEYS[1] and syncs agent key KEYS[2], so that the agent key
+// is deleted only when the connection owning it closes and no other connection of the user is online.
+var setOffline = redisv8.NewScript(`
+for i = 2, #ARGV do
+	redis.call("HDEL", KEYS[1], ARGV[i])
+end
+` + syncAgent + `
+return 0
+`)
+
+// redisStore keeps agent of each online user in key described by consts.UserOnlineAgentKeyPrefix, so that
+// other services find where users are connected to. Connections of user are kept in a hash to decide
+// which agent the key points to, see syncAgent.
+type redisStore struct {
+	client *redisv8.Client
+}
+
+var _ Store = &redisStore{}
+
+// NewRedisStore creates Store backed by redis.
+func NewRedisStore(cli *redisv8.Client) Store {
+	return &redisStore{
+		client: cli,
+	}
+}
+
+func groupByUID(conns []ConnInfo) map[types.ID][]ConnInfo {
+	m := make(map[types.ID][]ConnInfo)
+	for _, c := range conns {
+		m[c.UID] = append(m[c.UID], c)
+	}
+
+	return m
+}
+
+func onlineKeys(uid types.ID) []string {
+	return []string{onlineConnsKey(uid), consts.GetUserOnlineAgentKey(uid.Int64())}
+}
+
+func (r *redisStore) SetOnline(ctx context.Context, ttl time.Duration, conns ...ConnInfo) error {
+	if len(conns) == 0 {
+		return nil
+	}
+
+	// script is loaded once so that EvalSha works in pipeline
+	if err := setOnline.Load(ctx, r.client).Err(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	expireAt := strconv.FormatInt(now.Add(ttl).UnixMilli(), 10)
+	_, err := r.client.Pipelined(ctx, func(pipe redisv8.Pipeliner) error {
+		for uid, cs := range groupByUID(conns) {
+			args := make([]interface{}, 0, 2+2*len(cs))
+			args = append(args, now.UnixMilli(), ttl.Milliseconds())
+			for _, c := range cs {
+				args = append(args, c.field(), expireAt+":"+c.Agent)
+			}
+			setOnline.EvalSha(ctx, pipe, onlineKeys(uid), args...)
+		}
+		return nil
+	})
+	return err
+}
+
+func (r *redisStore) SetOffline(ctx context.Context, conns ...ConnInfo) error {
+	if len(conns) == 0 {
+		return nil
+	}
+
+	if err := setOffline.Load(ctx, r.client).Err(); err != nil {
+		return err
+	}
+
+	now := time.Now().UnixMilli()
+	_, err := r.client.Pipelined(ctx, func(pipe redisv8.Pipeliner) error {
+		for uid, cs := range groupByUID(conns) {
+			args := make([]interface{}, 0, 1+len(cs))
+			args = append(args, now)
+			for _, c := range cs {
+				args = append(args, c.field())
+			}
+			setOffline.EvalSha(ctx, pipe, onlineKeys(uid), args...)
+		}
+		return nil
+	})
+	return err
+}
+
+// WhereIs reads agent keys, which are what other services read too.
+func (r *redisStore) WhereIs(ctx context.Context, uids ...types.ID) (map[types.ID]string, error) {
+	result := make(map[types.ID]string, len(uids))
+	if len(uids) == 0 {
+		return result, nil
+	}
+
+	cmds := make([]*redisv8.StringCmd, len(uids))
+	_, err := r.client.Pipelined(ctx, func(pipe redisv8.Pipeliner) error {
+		for i, uid := range uids {
+			cmds[i] = pipe.Get(ctx, consts.GetUserOnlineAgentKey(uid.Int64()))
+		}
+		return nil
+	})
+	if err != nil && err != redisv8.Nil {
+		return nil, err
+	}
+
+	for i, cmd := range cmds {
+		agent, err := cmd.Result()
+		if err == redisv8.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		result[uids[i]] = agent
+	}
+
+	return result, nil
+}
+
+func (r *redisStore) Publish(ctx context.Context, e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return r.client.Publish(ctx, EventChannel, b).Err()
+}
+
+func (r *redisStore) Subscribe(ctx context.Context, f func(e *Event)) error {
+	sub := r.client.Subscribe(ctx, EventChannel)
+	defer sub.Close()
+
+	// wait for subscription confirmed
+	if _, err := sub.Receive(ctx); err != nil {
+		return err
+	}
+
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-ch:
+			if !ok {
+				return nil
+			}
+
+			e := new(Event)
+			if err := json.Unmarshal([]byte(msg.Payload), e); err != nil {
+				log.Error("presence decode event error", "payload", msg.Payload, "error", err)
+				continue
+			}
+			f(e)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package presence
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	redisv8 "github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/go-goim/core/pkg/consts"
+	"github.com/go-goim/core/pkg/types"
+)
+
+func TestRedisStore(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		mr    = miniredis.RunT(t)
+		store = NewRedisStore(redisv8.NewClient(&redisv8.Options{Addr: mr.Addr()}))
+		uid   = types.ID(3001)
+		key   = onlineConnsKey(uid)
+		agent = consts.GetUserOnlineAgentKey(uid.Int64())
+		a     = ConnInfo{UID: uid, Agent: "A", ID: "1"}
+		b     = ConnInfo{UID: uid, Agent: "B", ID: "1"}
+	)
+
+	tests := []struct {
+		name   string
+		do     func() error
+		agent  string // empty if offline
+		fields []string
+	}{
+		{
+			name:   "online at A",
+			do:     func() error { return store.SetOnline(ctx, time.Minute, a) },
+			agent:  "A",
+			fields: []string{"A/1"},
+		},
+		{
+			name: "reconnected at B, refreshed at A",
+			do: func() error {
+				if err := store.SetOnline(ctx, time.Minute, b); err != nil {
+					return err
+				}
+				return store.SetOnline(ctx, time.Minute, a)
+			},
+			agent:  "A",
+			fields: []string{"A/1", "B/1"},
+		},
+		{
+			name:   "another connection at A",
+			do:     func() error { return store.SetOnline(ctx, time.Minute, ConnInfo{UID: uid, Agent: "A", ID: "2"}) },
+			agent:  "A",
+			fields: []string{"A/1", "A/2", "B/1"},
+		},
+		{
+			name:   "closing connection not owning agent key keeps it",
+			do:     func() error { return store.SetOffline(ctx, ConnInfo{UID: uid, Agent: "A", ID: "1"}) },
+			agent:  "A",
+			fields: []string{"A/2", "B/1"},
+		},
+		{
+			name:   "late disconnect at A keeps B",
+			do:     func() error { return store.SetOffline(ctx, ConnInfo{UID: uid, Agent: "A", ID: "2"}) },
+			agent:  "B",
+			fields: []string{"B/1"},
+		},
+		{
+			name:  "offline at B",
+			do:    func() error { return store.SetOffline(ctx, b) },
+			agent: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.NoError(t, tt.do())
+
+			agents, err := store.WhereIs(ctx, uid, 3002)
+			assert.NoError(t, err)
+			if tt.agent == "" {
+				assert.Empty(t, agents)
+				assert.False(t, mr.Exists(key))
+				assert.False(t, mr.Exists(agent))
+				return
+			}
+
+			assert.Equal(t, map[types.ID]string{uid: tt.agent}, agents)
+			fields, err := mr.HKeys(key)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.fields, fields)
+			assert.Equal(t, time.Minute, mr.TTL(key))
+
+			// agent key is plain address of agent with ttl of the connection owning it
+			v, err := mr.Get(agent)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.agent, v)
+			assert.InDelta(t, time.Minute, mr.TTL(agent), float64(time.Second))
+		})
+	}
+}
+
+func TestRedisStore_Expire(t *testing.T) {
+	var (
+		ctx   = context.Background()
+		mr    = miniredis.RunT(t)
+		store = NewRedisStore(redisv8.NewClient(&redisv8.Options{Addr: mr.Addr()}))
+		uid   = types.ID(3003)
+		key   = onlineConnsKey(uid)
+	)
+
+	// connection left by crashed agent is not online after ttl, and removed by other agents
+	assert.NoError(t, store.SetOnline(ctx, 10*time.Millisecond, ConnInfo{UID: uid, Agent: "A", ID: "1"}))
+	time.Sleep(20 * time.Millisecond)
+	mr.FastForward(20 * time.Millisecond)
+	agents, err := store.WhereIs(ctx, uid)
+	assert.NoError(t, err)
+	assert.Empty(t, agents)
+
+	assert.NoError(t, store.SetOnline(ctx, time.Minute, ConnInfo{UID: uid, Agent: "B", ID: "1"}))
+	fields, err := mr.HKeys(key)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"B/1"}, fields)
+	v, err := mr.Get(consts.GetUserOnlineAgentKey(uid.Int64()))
+	assert.NoError(t, err)
+	assert.Equal(t, "B", v)
+}
@@ -0,0 +1,78 @@
+package presence
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-goim/core/pkg/types"
+)
+
+// EventType is the type of presence change.
+type EventType int
+
+const (
+	EventOnline EventType = iota + 1
+	EventOffline
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventOnline:
+		return "online"
+	case EventOffline:
+		return "offline"
+	default:
+		return "unknown"
+	}
+}
+
+// Event is published when a user goes online or offline on an agent.
+type Event struct {
+	UID   types.ID  `json:"uid"`
+	Type  EventType `json:"type"`
+	Agent string    `json:"agent"`
+	Time  int64     `json:"time"` // unix milli
+}
+
+// ConnInfo identifies a connection of user at agent.
+type ConnInfo struct {
+	UID   types.ID
+	Agent string
+	// ID is unique among connections of agent.
+	ID string
+}
+
+// field returns name of the connection among all connections of the user.
+func (c ConnInfo) field() string {
+	return c.Agent + "/" + c.ID
+}
+
+// Store keeps online connections of users and agents they are connected to, and broadcasts events to
+// all agents. A user is online while it has any online connection, so a late offline of a connection
+// never removes connections of the user at other agents.
+type Store interface {
+	// SetOnline sets conns online, it is called repeatedly within ttl to keep them online.
+	SetOnline(ctx context.Context, ttl time.Duration, conns ...ConnInfo) error
+	// SetOffline removes conns, other connections of their users are kept.
+	SetOffline(ctx context.Context, conns ...ConnInfo) error
+	// WhereIs returns agent of the most recently refreshed connection of each online uid,
+	// uids not online are not in the result.
+	WhereIs(ctx context.Context, uids ...types.ID) (map[types.ID]string, error)
+	// Publish sends e to subscribers of all agents.
+	Publish(ctx context.Context, e *Event) error
+	// Subscribe calls f with every published event until ctx is done.
+	Subscribe(ctx context.Context, f func(e *Event)) error
+}
+
+// preferConn reports whether connection at agent expiring at expireAt (unix ms) is preferred to the picked one.
+// The most recently refreshed connection wins, ties go to the smaller agent so that result is stable.
+func preferConn(agent string, expireAt int64, picked string, pickedAt int64) bool {
+	if picked == "" {
+		return true
+	}
+	if expireAt != pickedAt {
+		return expireAt > pickedAt
+	}
+
+	return agent < picked
+}
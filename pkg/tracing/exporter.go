@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+const (
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+
+	defaultOTLPEndpoint = "localhost:4317"
+)
+
+// NewExporter creates exporter by cfg, returns nil if exporter is not set.
+func NewExporter(cfg *Config) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case "":
+		return nil, nil
+	case ExporterStdout:
+		return NewStdoutExporter(os.Stdout), nil
+	case ExporterOTLP:
+		endpoint := cfg.Endpoint
+		if endpoint == "" {
+			endpoint = defaultOTLPEndpoint
+		}
+
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		// connects in background, never blocks
+		return otlptracegrpc.New(context.Background(), opts...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter: %s", cfg.Exporter)
+	}
+}
+
+// NewMemoryExporter returns exporter keeps spans in memory, use it in tests.
+func NewMemoryExporter() *tracetest.InMemoryExporter {
+	return tracetest.NewInMemoryExporter()
+}
+
+type stdoutExporter struct {
+	lock sync.Mutex
+	enc  *json.Encoder
+}
+
+// NewStdoutExporter writes each span as a json line to w.
+func NewStdoutExporter(w io.Writer) sdktrace.SpanExporter {
+	return &stdoutExporter{enc: json.NewEncoder(w)}
+}
+
+type stdoutSpan struct {
+	Name       string            `json:"name"`
+	Kind       string            `json:"kind"`
+	TraceID    string            `json:"trace_id"`
+	SpanID     string            `json:"span_id"`
+	ParentID   string            `json:"parent_id,omitempty"`
+	Start      time.Time         `json:"start"`
+	Duration   string            `json:"duration"`
+	Status     string            `json:"status"`
+	Error      string            `json:"error,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+func (e *stdoutExporter) ExportSpans(_ context.Context, spans []sdktrace.ReadOnlySpan) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	for _, s := range spans {
+		out := &stdoutSpan{
+			Name:     s.Name(),
+			Kind:     s.SpanKind().String(),
+			TraceID:  s.SpanContext().TraceID().String(),
+			SpanID:   s.SpanContext().SpanID().String(),
+			Start:    s.StartTime(),
+			Duration: s.EndTime().Sub(s.StartTime()).String(),
+			Status:   s.Status().Code.String(),
+			Error:    s.Status().Description,
+		}
+		if s.Parent().IsValid() {
+			out.ParentID = s.Parent().SpanID().String()
+		}
+		if attrs := s.Attributes(); len(attrs) > 0 {
+			out.Attributes = make(map[string]string, len(attrs))
+			for _, kv := range attrs {
+				out.Attributes[string(kv.Key)] = kv.Value.Emit()
+			}
+		}
+
+		if err := e.enc.Encode(out); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *stdoutExporter) Shutdown(context.Context) error {
+	return nil
+}
@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Config is tracing config, read from "tracing" of service config.
+type Config struct {
+	// Exporter is one of "stdout" and "otlp", spans are recorded for propagation and logs
+	// but not exported if empty.
+	Exporter string `json:"exporter"`
+	// Endpoint is address of otlp grpc collector, "localhost:4317" by default.
+	Endpoint string `json:"endpoint"`
+	// Insecure disables tls to otlp collector.
+	Insecure bool `json:"insecure"`
+	// SampleRatio is ratio of new traces sampled, all traces are sampled if <= 0.
+	// Traces started by upstream services follow their decision.
+	SampleRatio float64 `json:"sample_ratio"`
+}
+
+type options struct {
+	serviceName string
+	cfg         *Config
+	exporter    sdktrace.SpanExporter
+	syncExport  bool
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		cfg: new(Config),
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	return o
+}
+
+type Option func(o *options)
+
+// WithServiceName sets service.name of spans.
+func WithServiceName(name string) Option {
+	return func(o *options) {
+		o.serviceName = name
+	}
+}
+
+// WithConfig sets config, exporter of config is ignored if WithExporter is set.
+func WithConfig(cfg *Config) Option {
+	return func(o *options) {
+		if cfg != nil {
+			o.cfg = cfg
+		}
+	}
+}
+
+// WithExporter sets exporter directly, e.g. NewMemoryExporter() in tests.
+func WithExporter(e sdktrace.SpanExporter) Option {
+	return func(o *options) {
+		o.exporter = e
+	}
+}
+
+// WithSyncExport exports each span once it ends instead of in batch, only use it in tests and debugging.
+func WithSyncExport() Option {
+	return func(o *options) {
+		o.syncExport = true
+	}
+}
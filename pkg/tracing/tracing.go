@@ -0,0 +1,89 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/go-goim/core/pkg/graceful"
+)
+
+// TracerName is name of tracer of spans created by goim.
+const TracerName = "github.com/go-goim/core"
+
+var provider *sdktrace.TracerProvider
+
+// Init installs tracer provider and w3c trace context propagator as otel globals, so that kratos
+// tracing middleware and all instrumentations of goim use them. Provider is shut down on graceful shutdown.
+func Init(opts ...Option) error {
+	o := newOptions(opts...)
+
+	exporter := o.exporter
+	if exporter == nil {
+		var err error
+		if exporter, err = NewExporter(o.cfg); err != nil {
+			return err
+		}
+	}
+
+	sampler := sdktrace.AlwaysSample()
+	if r := o.cfg.SampleRatio; r > 0 && r < 1 {
+		sampler = sdktrace.TraceIDRatioBased(r)
+	}
+
+	tpOpts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceNameKey.String(o.serviceName))),
+	}
+	if exporter != nil {
+		if o.syncExport {
+			tpOpts = append(tpOpts, sdktrace.WithSyncer(exporter))
+		} else {
+			tpOpts = append(tpOpts, sdktrace.WithBatcher(exporter))
+		}
+	}
+
+	tp := sdktrace.NewTracerProvider(tpOpts...)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	provider = tp
+
+	graceful.RegisterPhase(graceful.PhaseClose, tp.Shutdown)
+	return nil
+}
+
+// Tracer returns tracer of goim from global provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// Propagator returns global propagator.
+func Propagator() propagation.TextMapPropagator {
+	return otel.GetTextMapPropagator()
+}
+
+// Flush exports all ended spans.
+func Flush(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+
+	return provider.ForceFlush(ctx)
+}
+
+// End records err on span if any and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
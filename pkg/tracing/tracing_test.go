@@ -0,0 +1,66 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestInit(t *testing.T) {
+	exporter := NewMemoryExporter()
+	assert.Nil(t, Init(WithServiceName("test"), WithExporter(exporter), WithSyncExport()))
+
+	ctx, parent := Tracer().Start(context.Background(), "parent")
+	carrier := propagation.MapCarrier{}
+	Propagator().Inject(ctx, carrier)
+	assert.NotEmpty(t, carrier.Get("traceparent"))
+
+	// continue trace from carrier like a downstream service
+	_, child := Tracer().Start(Propagator().Extract(context.Background(), carrier), "child")
+	End(child, errors.New("failed"))
+	End(parent, nil)
+	assert.Nil(t, Flush(context.Background()))
+
+	spans := exporter.GetSpans()
+	if assert.Len(t, spans, 2) {
+		assert.Equal(t, "child", spans[0].Name)
+		assert.Equal(t, codes.Error, spans[0].Status.Code)
+		assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent.SpanID())
+		assert.Equal(t, parent.SpanContext().TraceID(), spans[0].SpanContext.TraceID())
+		assert.Equal(t, codes.Unset, spans[1].Status.Code)
+	}
+}
+
+func TestNewExporter(t *testing.T) {
+	e, err := NewExporter(&Config{})
+	assert.Nil(t, err)
+	assert.Nil(t, e)
+
+	_, err = NewExporter(&Config{Exporter: "unknown"})
+	assert.NotNil(t, err)
+
+	e, err = NewExporter(&Config{Exporter: ExporterOTLP, Insecure: true})
+	assert.Nil(t, err)
+	assert.NotNil(t, e)
+	_ = e.Shutdown(context.Background())
+}
+
+func TestStdoutExporter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(NewStdoutExporter(buf)))
+	_, span := tp.Tracer(TracerName).Start(context.Background(), "stdout")
+	End(span, errors.New("failed"))
+
+	out := new(stdoutSpan)
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), out))
+	assert.Equal(t, "stdout", out.Name)
+	assert.Equal(t, span.SpanContext().TraceID().String(), out.TraceID)
+	assert.Equal(t, "failed", out.Error)
+}
@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/go-goim/core/pkg/metrics"
+)
+
+var (
+	// pools are running pools reported by metrics
+	pools sync.Map // *Pool -> struct{}
+
+	queueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "worker",
+		Name:      "queue_depth",
+		Help:      "Number of tasks waiting in queue of all worker pools.",
+	}, func() float64 {
+		return sumPools(func(p *Pool) int { return p.taskList.Len() })
+	})
+
+	runningWorkers = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "worker",
+		Name:      "running_workers",
+		Help:      "Number of running workers of all worker pools.",
+	}, func() float64 {
+		return sumPools(func(p *Pool) int { return p.curRunningWorkerNum() })
+	})
+
+	rejectedTasks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "worker",
+		Name:      "rejected_tasks_total",
+		Help:      "Total number of tasks rejected by worker pools.",
+	}, []string{"reason"})
+)
+
+func sumPools(f func(p *Pool) int) float64 {
+	var n int
+	pools.Range(func(key, _ interface{}) bool {
+		p := key.(*Pool)
+		p.lock.Lock()
+		n += f(p)
+		p.lock.Unlock()
+		return true
+	})
+
+	return float64(n)
+}
+
+// reject counts rejected task by status and returns status as result.
+func reject(s TaskStatus) TaskResult {
+	var reason string
+	switch s {
+	case TaskStatusQueueFull:
+		reason = "queue_full"
+	case TaskStatusTooManyWorker:
+		reason = "too_many_worker"
+	case TaskStatusPoolClosed:
+		reason = "pool_closed"
+	default:
+		reason = "unknown"
+	}
+
+	rejectedTasks.WithLabelValues(reason).Inc()
+	return s
+}
@@ -8,6 +8,7 @@ import (
 
 	"go.uber.org/atomic"
 
+	"github.com/go-goim/core/pkg/metrics"
 	"github.com/go-goim/core/pkg/util"
 )
 
@@ -46,17 +47,20 @@ func NewPool(maxWorker, poolSize int) *Pool {
 		p.poolSize = poolSize
 	}
 
+	metrics.Register(queueDepth, runningWorkers, rejectedTasks)
+	pools.Store(p, struct{}{})
+
 	go p.consumeQueue()
 	return p
 }
 
 func (p *Pool) Submit(ctx context.Context, tf TaskFunc, concurrence int) TaskResult {
 	if p.stopFlag.Load() {
-		return TaskStatusPoolClosed
+		return reject(TaskStatusPoolClosed)
 	}
 
 	if concurrence > p.maxWorker {
-		return TaskStatusTooManyWorker
+		return reject(TaskStatusTooManyWorker)
 	}
 
 	// check if there has any worker place left
@@ -72,12 +76,13 @@ func (p *Pool) Submit(ctx context.Context, tf TaskFunc, concurrence int) TaskRes
 		return t
 	}
 
-	return TaskStatusQueueFull
+	return reject(TaskStatusQueueFull)
 }
 
 func (p *Pool) Shutdown(ctx context.Context) error {
 	p.stopChan <- struct{}{}
 	p.stopFlag.Store(true)
+	pools.Delete(p)
 	// stop all workers
 	for e := p.workerSets.Front(); e != nil; e = e.Next() {
 		ws := e.Value.(*workerSet)